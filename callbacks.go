@@ -4,40 +4,45 @@ package cec
 import "C"
 
 import (
-	"log"
+	"time"
 	"unsafe"
 )
 
 //export logMessageCallback
 func logMessageCallback(c unsafe.Pointer, msg *C.cec_log_message) C.int {
-	log.Println("cec msg rx:", C.GoString(msg.message))
-
 	conn := (*Connection)(c)
-	conn.messageReceived(C.GoString(msg.message))
+	// disabled levels return before any conversion or logging
+	if !conn.logLevelEnabled(int(msg.level)) {
+		return 0
+	}
+	text := C.GoString(msg.message)
+	conn.logf("cec msg rx: %s", text)
+
+	conn.messageReceived(LogMessage{Text: text, Level: int(msg.level), Time: time.Now()})
 	return 0
 }
 
 //export keyPressed
 func keyPressed(c unsafe.Pointer, code *C.cec_keypress) C.int {
-	log.Println("cec keycode rx:", code)
-
 	conn := (*Connection)(c)
-	conn.keyPressed(int(C.int(code.keycode)))
+	conn.logf("cec keycode rx: %d (%dms)", int(code.keycode), int(code.duration))
+
+	conn.keyPressed(int(C.int(code.keycode)), time.Duration(code.duration)*time.Millisecond)
 	return 0
 }
 
 //export commandReceived
 func commandReceived(c unsafe.Pointer, msg *C.cec_command) C.int {
-	log.Printf("cec command rx: %v", msg)
-
 	conn := (*Connection)(c)
+	conn.logf("cec command rx: %v", msg)
+
 	cmd := &Command{
-		initiator:   uint32(msg.initiator),
-		destination: uint32(msg.destination),
-		ack:         int8(msg.ack),
-		eom:         int8(msg.eom),
-		opcode:      int(msg.opcode),
-		// parameters: todo
+		initiator:        uint32(msg.initiator),
+		destination:      uint32(msg.destination),
+		ack:              int8(msg.ack),
+		eom:              int8(msg.eom),
+		opcode:           int(msg.opcode),
+		parameters:       C.GoBytes(unsafe.Pointer(&msg.parameters.data[0]), C.int(msg.parameters.size)),
 		opcode_set:       int8(msg.opcode_set),
 		transmit_timeout: int32(msg.transmit_timeout),
 		Operation:        opcodes[int(msg.opcode)],
@@ -46,3 +51,20 @@ func commandReceived(c unsafe.Pointer, msg *C.cec_command) C.int {
 
 	return 0
 }
+
+//export alertCallback
+func alertCallback(c unsafe.Pointer, alert C.libcec_alert, param C.libcec_parameter) C.int {
+	conn := (*Connection)(c)
+	conn.logf("cec alert rx: %d", int(alert))
+
+	var text string
+	if param.paramType == C.CEC_PARAMETER_TYPE_STRING && param.paramData != nil {
+		text = C.GoString((*C.char)(param.paramData))
+	}
+	conn.alertReceived(Alert{Code: AlertCode(alert), Param: text})
+
+	if alert == C.CEC_ALERT_CONNECTION_LOST {
+		conn.connectionLost()
+	}
+	return 0
+}
@@ -1,7 +1,265 @@
 package cec
 
-import "testing"
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
 
 func TestKeypress(t *testing.T) {
 
 }
+
+func TestInjectCommand(t *testing.T) {
+	c := NewTestConnection()
+	c.Commands = make(chan *Command, 1)
+	c.PowerEvents = make(chan PowerEvent, 1)
+	standby := c.SubscribeOpcodes(0x36)
+
+	cmd, _ := ParseCommand("40:90:01")
+	c.InjectCommand(cmd)
+
+	if received := <-c.Commands; received != cmd {
+		t.Errorf("unexpected command %v", received)
+	}
+	if ev := <-c.PowerEvents; ev.Address != 4 || ev.Status != PowerStandby {
+		t.Errorf("unexpected power event %+v", ev)
+	}
+	select {
+	case received := <-standby:
+		t.Errorf("unexpected command %v on the STANDBY subscription", received)
+	default:
+	}
+
+	c.Close()
+	if _, ok := <-standby; ok {
+		t.Error("subscription not closed")
+	}
+
+	// the channels are closed, the command must be dropped
+	c.InjectCommand(cmd)
+}
+
+func TestWaitFor(t *testing.T) {
+	c := NewTestConnection()
+	defer c.Close()
+
+	fromTuner, _ := ParseCommand("3f:82:10:00")
+	fromPlayback, _ := ParseCommand("4f:82:20:00")
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			c.InjectCommand(fromTuner)
+			c.InjectCommand(fromPlayback)
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	cmd, err := c.WaitFor(ctx, 0x82, 4)
+	if err != nil || cmd != fromPlayback {
+		t.Errorf("WaitFor returned %v, %v", cmd, err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.WaitFor(ctx, 0x36, -1); err != ErrTimeout {
+		t.Errorf("expected ErrTimeout, got %v", err)
+	}
+}
+
+func TestOnCommand(t *testing.T) {
+	c := NewTestConnection()
+	defer c.Close()
+
+	received := make(chan *Command, 1)
+	c.OnCommand(func(cmd *Command) { received <- cmd })
+
+	cmd, _ := ParseCommand("40:04")
+	c.InjectCommand(cmd)
+
+	select {
+	case got := <-received:
+		if got != cmd {
+			t.Errorf("unexpected command %v", got)
+		}
+	case <-time.After(time.Second):
+		t.Error("handler not called")
+	}
+}
+
+type testResponder struct{}
+
+func (testResponder) PowerStatus() PowerStatus { return PowerStandby }
+func (testResponder) OSDName() string          { return "cec.go" }
+func (testResponder) VendorID() uint64         { return 0x0000F0 }
+
+func TestResponder(t *testing.T) {
+	c := NewTestConnection()
+	defer c.Close()
+
+	sent := make(chan string, 1)
+	c.send = func(cmd *Command) error {
+		sent <- strings.Fields(cmd.String())[0]
+		return nil
+	}
+	c.SetResponder(testResponder{})
+
+	for request, reply := range map[string]string{
+		"04:8f": "40:90:01",
+		"04:46": "40:47:63:65:63:2e:67:6f",
+		"04:8c": "4f:87:00:00:f0",
+	} {
+		cmd, _ := ParseCommand(request)
+		c.InjectCommand(cmd)
+		select {
+		case got := <-sent:
+			if got != reply {
+				t.Errorf("%s: expected reply %s, got %s", request, reply, got)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("%s: no reply", request)
+		}
+	}
+}
+
+func TestVendorIDBroadcast(t *testing.T) {
+	c := NewTestConnection()
+	defer c.Close()
+
+	c.SetCacheTTL(time.Minute)
+	c.cacheDevice(Device{LogicalAddress: 4, OSDName: "Player"})
+
+	cmd, _ := ParseCommand("4f:87:00:00:f0")
+	c.InjectCommand(cmd)
+
+	if dev, ok := c.cachedDevice(4); !ok || dev.Vendor != "Samsung" || dev.OSDName != "Player" {
+		t.Errorf("unexpected cached device %+v, %v", dev, ok)
+	}
+}
+
+func TestWaitForPowerState(t *testing.T) {
+	c := NewTestConnection()
+	defer c.Close()
+
+	transition, _ := ParseCommand("40:90:02")
+	on, _ := ParseCommand("40:90:00")
+	var reportedOn atomic.Bool
+	c.send = func(cmd *Command) error {
+		if cmd.Opcode() == 0x8F {
+			go func() {
+				c.InjectCommand(transition)
+				time.Sleep(20 * time.Millisecond)
+				reportedOn.Store(true)
+				c.InjectCommand(on)
+			}()
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := c.WaitForPowerState(ctx, 4, PowerOn); err != nil {
+		t.Fatal(err)
+	}
+	if !reportedOn.Load() {
+		t.Error("returned on PowerTransitionToOn")
+	}
+}
+
+func TestWaitForPowerStateCancel(t *testing.T) {
+	c := NewTestConnection()
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.WaitForPowerState(ctx, 4, PowerOn); err != ErrTimeout {
+		t.Errorf("expected ErrTimeout, got %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err := c.WaitForPowerState(ctx, 4, PowerOn); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestPowerOn(t *testing.T) {
+	c := NewTestConnection()
+	defer c.Close()
+
+	var sent []string
+	c.send = func(cmd *Command) error {
+		sent = append(sent, strings.Fields(cmd.String())[0])
+		return nil
+	}
+	if err := c.PowerOn(AddrTV); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 1 || sent[0] != "40:04" {
+		t.Errorf("unexpected frames %v", sent)
+	}
+}
+
+func TestTestAddress(t *testing.T) {
+	c := NewTestConnection()
+	defer c.Close()
+
+	c.SetTestAddress(AddrAudioSystem, 0x2100)
+	if addr, err := c.MyPhysicalAddress(); err != nil || addr != 0x2100 {
+		t.Errorf("unexpected physical address %04x, %v", addr, err)
+	}
+	if addresses, err := c.MyLogicalAddresses(); err != nil || len(addresses) != 1 || addresses[0] != AddrAudioSystem {
+		t.Errorf("unexpected logical addresses %v, %v", addresses, err)
+	}
+}
+
+func TestStartARC(t *testing.T) {
+	c := NewTestConnection()
+	defer c.Close()
+	c.SetInitiator(AddrAudioSystem)
+
+	sent := make(chan string, 2)
+	initiate, _ := ParseCommand("05:c0")
+	c.send = func(cmd *Command) error {
+		sent <- strings.Fields(cmd.String())[0]
+		if cmd.Opcode() == 0xC3 {
+			go c.InjectCommand(initiate)
+		}
+		return nil
+	}
+
+	if err := c.StartARC(); err != nil {
+		t.Fatal(err)
+	}
+	if request, report := <-sent, <-sent; request != "50:c3" || report != "50:c1" {
+		t.Errorf("unexpected frames %s, %s", request, report)
+	}
+}
+
+func TestActiveSource(t *testing.T) {
+	c := NewTestConnection()
+	defer c.Close()
+
+	announcement, _ := ParseCommand("8f:82:21:00")
+	c.send = func(cmd *Command) error {
+		if cmd.Opcode() == 0x85 {
+			go c.InjectCommand(announcement)
+		}
+		return nil
+	}
+
+	if addr, err := c.ActiveSource(); err != nil || addr != AddrPlayback2 {
+		t.Errorf("unexpected active source %d, %v", addr, err)
+	}
+}
@@ -1,20 +1,417 @@
 package cec
 
 import (
+	"context"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 )
 
 // Device structure
 type Device struct {
-	OSDName         string
-	Vendor          string
-	LogicalAddress  int
-	ActiveSource    bool
-	PowerStatus     string
-	PhysicalAddress string
+	OSDName         string      `json:"osd_name"`
+	Vendor          string      `json:"vendor"`
+	LogicalAddress  int         `json:"logical_address"`
+	ActiveSource    bool        `json:"active_source"`
+	PowerStatus     PowerStatus `json:"power_status"`
+	PhysicalAddress string      `json:"physical_address"`
+	CECVersion      string      `json:"cec_version"`
+}
+
+// DeviceType - the type of a CEC device
+type DeviceType int
+
+// Device types, the values match libcec's cec_device_type
+const (
+	DeviceTypeTV          DeviceType = 0
+	DeviceTypeRecording   DeviceType = 1
+	DeviceTypeReserved    DeviceType = 2
+	DeviceTypeTuner       DeviceType = 3
+	DeviceTypePlayback    DeviceType = 4
+	DeviceTypeAudioSystem DeviceType = 5
+)
+
+// addressDeviceTypes - the device type of each logical address
+var addressDeviceTypes = [16]DeviceType{
+	DeviceTypeTV, DeviceTypeRecording, DeviceTypeRecording, DeviceTypeTuner,
+	DeviceTypePlayback, DeviceTypeAudioSystem, DeviceTypeTuner, DeviceTypeTuner,
+	DeviceTypePlayback, DeviceTypeRecording, DeviceTypeTuner, DeviceTypePlayback,
+	DeviceTypeReserved, DeviceTypeReserved, DeviceTypeReserved, DeviceTypeReserved}
+
+// OpenOptions - options for OpenWithOptions. HDMIPort is the port of the
+// BaseDevice (a logical address, usually the TV) the adapter is connected
+// to, it is used to derive our physical address. DeviceTypes defaults to a
+// recording device, libcec supports up to 5 types. Logger and LogLevel are
+// applied before the adapter is opened, like SetLogger and SetLogLevel.
+// ConnectTimeout limits how long libcec tries to connect to the adapter
+// (libcec's default if zero), OpenRetries is the number of times opening is
+// retried after a failure, with a growing pause in between
+type OpenOptions struct {
+	DeviceName     string
+	HDMIPort       int
+	BaseDevice     int
+	DeviceTypes    []DeviceType
+	Logger         Logger
+	LogLevel       int
+	ConnectTimeout time.Duration
+	OpenRetries    int
+}
+
+// AdapterInfo - a CEC adapter connected to this system. FirmwareBuildDate
+// is zero if the adapter doesn't report it, LibCECVersion is only set by
+// Connection.AdapterInfo
+type AdapterInfo struct {
+	Path              string
+	Comm              string
+	FirmwareVersion   int
+	FirmwareBuildDate time.Time
+	LibCECVersion     string
+}
+
+// PowerStatus - the power status of a device
+type PowerStatus int
+
+// Power states, the values match the CEC power status operand
+const (
+	PowerOn                  PowerStatus = 0x00
+	PowerStandby             PowerStatus = 0x01
+	PowerTransitionToOn      PowerStatus = 0x02
+	PowerTransitionToStandby PowerStatus = 0x03
+	PowerUnknown             PowerStatus = 0x99
+)
+
+func (p PowerStatus) String() string {
+	switch p {
+	case PowerOn:
+		return "on"
+	case PowerStandby:
+		return "standby"
+	case PowerTransitionToOn:
+		return "starting"
+	case PowerTransitionToStandby:
+		return "shutting down"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText - encode the power status as its name (e.g. "standby")
+func (p PowerStatus) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// PowerEvent - a device reported its power status, delivered on the
+// PowerEvents channel of a Connection (dropped if the reader isn't ready)
+type PowerEvent struct {
+	Address int
+	Status  PowerStatus
+}
+
+// KeyEvent - a key was pressed on a remote, delivered on the KeyEvents
+// channel of a Connection (dropped if the reader isn't ready). Name is empty
+// for unknown key codes. libcec reports a key when it is pressed, with a
+// zero Duration, and again when it is released, with the time it was held
+type KeyEvent struct {
+	Code     int
+	Name     string
+	Duration time.Duration
+}
+
+// Released - check if the event reports the release of a key, its Duration
+// tells a tap from a long press
+func (e KeyEvent) Released() bool {
+	return e.Duration > 0
+}
+
+// RoutingEvent - the active route changed from one physical address to
+// another, delivered on the RoutingEvents channel of a Connection (dropped
+// if the reader isn't ready). ROUTING_INFORMATION only carries the new
+// route, From is 0xFFFF in that case
+type RoutingEvent struct {
+	From uint16
+	To   uint16
+}
+
+// AbortEvent - a device rejected a command with FEATURE_ABORT or sent an
+// ABORT, delivered on the AbortEvents channel of a Connection (dropped if the
+// reader isn't ready). An ABORT doesn't reject anything, Opcode is -1 and
+// Reason is empty in that case
+type AbortEvent struct {
+	From   int
+	To     int
+	Opcode int
+	Reason string
+}
+
+// AlertCode - the type of an alert raised by libcec
+type AlertCode int
+
+// Alert codes, the values match libcec's libcec_alert
+const (
+	AlertServiceDevice AlertCode = iota
+	AlertConnectionLost
+	AlertPermissionError
+	AlertPortBusy
+	AlertPhysicalAddressError
+	AlertTVPollFailed
+)
+
+func (a AlertCode) String() string {
+	switch a {
+	case AlertServiceDevice:
+		return "service device"
+	case AlertConnectionLost:
+		return "connection lost"
+	case AlertPermissionError:
+		return "permission error"
+	case AlertPortBusy:
+		return "port busy"
+	case AlertPhysicalAddressError:
+		return "physical address error"
+	case AlertTVPollFailed:
+		return "TV poll failed"
+	default:
+		return "unknown"
+	}
+}
+
+// Alert - an alert raised by libcec with its optional parameter, delivered
+// on the Alerts channel of a Connection (dropped if the reader isn't ready)
+type Alert struct {
+	Code  AlertCode
+	Param string
+}
+
+// ReconnectEvent - the connection to the adapter was lost and has been
+// restored, delivered on the Reconnects channel of a Connection
+type ReconnectEvent struct {
+	Lost     time.Time
+	Restored time.Time
+	Attempts int
+}
+
+// CECVersion - a version of the CEC spec, ordered from oldest to newest
+type CECVersion int
+
+// CEC versions, the values match the CEC version operand
+const (
+	CECVersion12  CECVersion = 0x01
+	CECVersion12a CECVersion = 0x02
+	CECVersion13  CECVersion = 0x03
+	CECVersion13a CECVersion = 0x04
+	CECVersion14  CECVersion = 0x05
+	CECVersion20  CECVersion = 0x06
+)
+
+func (v CECVersion) String() string {
+	if version, ok := cecVersions[int(v)]; ok {
+		return version
+	}
+	return "unknown"
+}
+
+// AtLeast - check if the version is the same as or newer than other
+func (v CECVersion) AtLeast(other CECVersion) bool {
+	return v >= other
+}
+
+// AudioStatus - the state of the audio system, see AudioSystemStatus
+type AudioStatus struct {
+	SystemAudioMode bool
+	Volume          int
+	Muted           bool
+}
+
+// HealthEvent - the TV stopped (or started again) acknowledging the polls
+// sent by the keep-alive, delivered on the HealthEvents channel of a
+// Connection (dropped if the reader isn't ready)
+type HealthEvent struct {
+	Healthy bool
+	Time    time.Time
+}
+
+// OSDDuration - how long an OSD string is displayed
+type OSDDuration int
+
+// OSD display control modes
+const (
+	OSDDefaultTime   OSDDuration = 0x00
+	OSDUntilCleared  OSDDuration = 0x40
+	OSDClearPrevious OSDDuration = 0x80
+)
+
+// DeckControlMode - a transport command for a recording or playback device
+type DeckControlMode int
+
+// Deck control modes, the values match the CEC deck control mode operand
+const (
+	DeckSkipForward DeckControlMode = 0x01
+	DeckSkipBack    DeckControlMode = 0x02
+	DeckStop        DeckControlMode = 0x03
+	DeckEject       DeckControlMode = 0x04
+)
+
+// DeckStatus - the transport state of a recording or playback device
+type DeckStatus int
+
+// Deck states, the values match the CEC deck info operand
+const (
+	DeckPlay               DeckStatus = 0x11
+	DeckRecord             DeckStatus = 0x12
+	DeckPlayReverse        DeckStatus = 0x13
+	DeckStill              DeckStatus = 0x14
+	DeckSlow               DeckStatus = 0x15
+	DeckSlowReverse        DeckStatus = 0x16
+	DeckFastForward        DeckStatus = 0x17
+	DeckFastReverse        DeckStatus = 0x18
+	DeckNoMedia            DeckStatus = 0x19
+	DeckStopped            DeckStatus = 0x1A
+	DeckSkipForwardWind    DeckStatus = 0x1B
+	DeckSkipReverseRewind  DeckStatus = 0x1C
+	DeckIndexSearchForward DeckStatus = 0x1D
+	DeckIndexSearchReverse DeckStatus = 0x1E
+	DeckOtherStatus        DeckStatus = 0x1F
+)
+
+var deckStatusNames = map[DeckStatus]string{
+	DeckPlay: "play", DeckRecord: "record", DeckPlayReverse: "play reverse",
+	DeckStill: "still", DeckSlow: "slow", DeckSlowReverse: "slow reverse",
+	DeckFastForward: "fast forward", DeckFastReverse: "fast reverse",
+	DeckNoMedia: "no media", DeckStopped: "stop",
+	DeckSkipForwardWind: "skip forward", DeckSkipReverseRewind: "skip reverse",
+	DeckIndexSearchForward: "index search forward",
+	DeckIndexSearchReverse: "index search reverse", DeckOtherStatus: "other"}
+
+func (d DeckStatus) String() string {
+	if name, ok := deckStatusNames[d]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown (0x%02x)", int(d))
+}
+
+// TimerProgram - a recording timer on a recording device. Repeat lists the
+// weekdays the recording is repeated on, an empty list records once.
+// Service is the digital service identification of the channel (7 bytes,
+// the format depends on the broadcast system)
+type TimerProgram struct {
+	Day      int
+	Month    time.Month
+	Hour     int
+	Minute   int
+	Duration time.Duration
+	Repeat   []time.Weekday
+	Service  [7]byte
+}
+
+// operands - encode the timer as the operands of SET_DIGITAL_TIMER and
+// CLEAR_DIGITAL_TIMER
+func (t TimerProgram) operands() ([]byte, error) {
+	hours := int(t.Duration / time.Hour)
+	minutes := int(t.Duration % time.Hour / time.Minute)
+	switch {
+	case t.Day < 1 || t.Day > 31:
+		return nil, errors.New("Invalid timer day")
+	case t.Month < time.January || t.Month > time.December:
+		return nil, errors.New("Invalid timer month")
+	case t.Hour < 0 || t.Hour > 23 || t.Minute < 0 || t.Minute > 59:
+		return nil, errors.New("Invalid timer start time")
+	case t.Duration <= 0 || hours > 99:
+		return nil, errors.New("Invalid timer duration")
+	}
+
+	var repeat byte
+	for _, day := range t.Repeat {
+		repeat |= 1 << uint(day)
+	}
+
+	operands := []byte{byte(t.Day), byte(t.Month), bcd(t.Hour), bcd(t.Minute),
+		bcd(hours), bcd(minutes), repeat}
+	return append(operands, t.Service[:]...), nil
+}
+
+// RecordSource - what a recording device should record, created with one of
+// the Record* functions
+type RecordSource struct {
+	params []byte
+}
+
+// RecordOwnSource - record the source currently selected on the recording
+// device
+func RecordOwnSource() RecordSource {
+	return RecordSource{[]byte{0x01}}
+}
+
+// RecordDigitalService - record a digital channel, given by its digital
+// service identification (the format depends on the broadcast system)
+func RecordDigitalService(service [7]byte) RecordSource {
+	return RecordSource{append([]byte{0x02}, service[:]...)}
+}
+
+// RecordAnalogueService - record an analogue channel, given by the CEC
+// analogue broadcast type, frequency (in 62.5kHz steps) and broadcast system
+func RecordAnalogueService(broadcastType byte, frequency uint16, system byte) RecordSource {
+	return RecordSource{[]byte{0x03, broadcastType, byte(frequency >> 8), byte(frequency), system}}
+}
+
+// RecordExternalPlug - record an external input of the recording device
+func RecordExternalPlug(plug int) RecordSource {
+	return RecordSource{[]byte{0x04, byte(plug)}}
+}
+
+// RecordExternalPhysicalAddress - record the device with the given
+// physical address
+func RecordExternalPhysicalAddress(addr uint16) RecordSource {
+	return RecordSource{[]byte{0x05, byte(addr >> 8), byte(addr)}}
+}
+
+// RecordStatus - the state reported by a recording device in RECORD_STATUS
+type RecordStatus int
+
+var recordStatusNames = map[RecordStatus]string{
+	0x01: "recording currently selected source",
+	0x02: "recording digital service", 0x03: "recording analogue service",
+	0x04: "recording external input",
+	0x05: "unable to record digital service", 0x06: "unable to record analogue service",
+	0x07: "unable to select required service", 0x09: "invalid external plug number",
+	0x0A: "invalid external physical address", 0x0B: "CA system not supported",
+	0x0C: "no or insufficient CA entitlements", 0x0D: "not allowed to copy source",
+	0x0E: "no further copies allowed", 0x10: "no media", 0x11: "playing",
+	0x12: "already recording", 0x13: "media protected", 0x14: "no source signal",
+	0x15: "media problem", 0x16: "not enough space available", 0x17: "parental lock on",
+	0x1A: "recording terminated normally", 0x1B: "recording has already terminated",
+	0x1F: "other reason"}
+
+func (r RecordStatus) String() string {
+	if name, ok := recordStatusNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown (0x%02x)", int(r))
+}
+
+// Recording - check if the status reports a recording in progress
+func (r RecordStatus) Recording() bool {
+	return r >= 0x01 && r <= 0x04
+}
+
+// bcd - encode a number from 0 to 99 as binary coded decimal
+func bcd(n int) byte {
+	return byte(n/10<<4 | n%10)
+}
+
+// LogMessage - a log message emitted by libcec, delivered on the
+// LogMessages channel of a Connection (dropped if the reader isn't ready)
+type LogMessage struct {
+	Text  string
+	Level int
+	Time  time.Time
 }
 
 type Command struct {
@@ -29,11 +426,240 @@ type Command struct {
 	Operation        string
 }
 
+// Initiator - the logical address of the device that sent the command
+func (cmd *Command) Initiator() int {
+	return int(cmd.initiator)
+}
+
+// Destination - the logical address the command was sent to
+func (cmd *Command) Destination() int {
+	return int(cmd.destination)
+}
+
+// Opcode - the opcode of the command
+func (cmd *Command) Opcode() int {
+	return cmd.opcode
+}
+
+// Acked - true when the ACK bit of the command is set
+func (cmd *Command) Acked() bool {
+	return cmd.ack == 1
+}
+
+// EOM - true when the EOM (end of message) bit of the command is set
+func (cmd *Command) EOM() bool {
+	return cmd.eom == 1
+}
+
+// Parameters - the parameters attached to the command
+func (cmd *Command) Parameters() []byte {
+	return cmd.parameters
+}
+
+// String - the command as hex frame with its operation and the devices
+// involved (e.g. "04:36 STANDBY (TV→Playback)")
+func (cmd *Command) String() string {
+	var frame []string
+	for _, b := range cmd.Bytes() {
+		frame = append(frame, fmt.Sprintf("%02x", b))
+	}
+	operation := "POLL"
+	if cmd.opcode_set == 1 {
+		operation = opcodes[cmd.opcode]
+	}
+
+	return fmt.Sprintf("%s %s (%s→%s)", strings.Join(frame, ":"), operation,
+		GetLogicalNameByAddress(int(cmd.initiator)), GetLogicalNameByAddress(int(cmd.destination)))
+}
+
+// MarshalJSON - encode the command as JSON, the opcode and parameters are
+// encoded in hex
+func (cmd *Command) MarshalJSON() ([]byte, error) {
+	opcode, operation := "", "POLL"
+	if cmd.opcode_set == 1 {
+		opcode, operation = fmt.Sprintf("0x%02x", cmd.opcode), opcodes[cmd.opcode]
+	}
+
+	return json.Marshal(struct {
+		Initiator   int    `json:"initiator"`
+		Destination int    `json:"destination"`
+		Opcode      string `json:"opcode"`
+		Operation   string `json:"operation"`
+		Parameters  string `json:"parameters"`
+	}{
+		Initiator:   int(cmd.initiator),
+		Destination: int(cmd.destination),
+		Opcode:      opcode,
+		Operation:   operation,
+		Parameters:  hex.EncodeToString(cmd.parameters),
+	})
+}
+
+// String - a short description of the device (e.g. "TV (0.0.0.0) Sony —
+// on, active source")
+func (d Device) String() string {
+	name := d.OSDName
+	if name == "" {
+		name = GetLogicalNameByAddress(d.LogicalAddress)
+	}
+
+	s := fmt.Sprintf("%s (%s) %s — %s", name, d.PhysicalAddress, d.Vendor, d.PowerStatus)
+	if d.ActiveSource {
+		s += ", active source"
+	}
+	return s
+}
+
+var (
+	// ErrInvalidKey - the key is neither a string nor an int
+	ErrInvalidKey = errors.New("Invalid key type")
+	// ErrUnknownKey - the key name doesn't match any known key
+	ErrUnknownKey = errors.New("Unknown key name")
+	// ErrKeyHeld - the key press was sent, but the release failed, so the
+	// device may still consider the key held down
+	ErrKeyHeld = errors.New("Key left pressed")
+	// ErrClosed - the connection has been closed
+	ErrClosed = errors.New("Connection closed")
+	// ErrNoAudioSystem - there's no audio system on the bus
+	ErrNoAudioSystem = errors.New("No audio system present")
+	// ErrTimeout - a device didn't reply in time
+	ErrTimeout = errors.New("Timeout waiting for a reply")
+	// ErrOSDNameTruncated - the OSD name was longer than 14 characters and
+	// has been truncated
+	ErrOSDNameTruncated = errors.New("OSD name truncated to 14 characters")
+	// ErrNotACKed - a command wasn't acknowledged by its destination
+	ErrNotACKed = errors.New("Command not acknowledged")
+	// ErrNoAdapter - no matching CEC adapter was found
+	ErrNoAdapter = errors.New("No adapter found")
+	// ErrDeviceNotPresent - there's no device at the logical address
+	ErrDeviceNotPresent = errors.New("Device not present")
+)
+
+// OpcodeGroup - a group of opcodes that belong to the same CEC feature
+type OpcodeGroup int
+
+// Opcode groups, ARC belongs to GroupAudio and the menu, OSD and remote
+// control features to GroupUserInterface
+const (
+	GroupGeneral OpcodeGroup = iota
+	GroupRouting
+	GroupPower
+	GroupSystemInfo
+	GroupAudio
+	GroupRecording
+	GroupTimers
+	GroupDeck
+	GroupTuner
+	GroupVendor
+	GroupUserInterface
+)
+
+// opcodeGroups - the group of each opcode in the opcodes table except NONE
+var opcodeGroups = map[int]OpcodeGroup{
+	0x00: GroupGeneral, 0xFF: GroupGeneral, 0xF8: GroupGeneral,
+
+	0x82: GroupRouting, 0x04: GroupRouting, 0x0D: GroupRouting,
+	0x9D: GroupRouting, 0x85: GroupRouting, 0x80: GroupRouting,
+	0x81: GroupRouting, 0x86: GroupRouting,
+
+	0x36: GroupPower, 0x8F: GroupPower, 0x90: GroupPower,
+
+	0x9E: GroupSystemInfo, 0x9F: GroupSystemInfo, 0x83: GroupSystemInfo,
+	0x84: GroupSystemInfo, 0x91: GroupSystemInfo, 0x32: GroupSystemInfo,
+
+	0x71: GroupAudio, 0x7A: GroupAudio, 0x7D: GroupAudio, 0x7E: GroupAudio,
+	0x70: GroupAudio, 0x72: GroupAudio, 0x9A: GroupAudio, 0xC0: GroupAudio,
+	0xC1: GroupAudio, 0xC2: GroupAudio, 0xC3: GroupAudio, 0xC4: GroupAudio,
+	0xC5: GroupAudio,
+
+	0x09: GroupRecording, 0x0A: GroupRecording, 0x0B: GroupRecording,
+	0x0F: GroupRecording,
+
+	0x33: GroupTimers, 0x34: GroupTimers, 0x35: GroupTimers, 0x43: GroupTimers,
+	0x67: GroupTimers, 0x97: GroupTimers, 0x99: GroupTimers, 0xA1: GroupTimers,
+	0xA2: GroupTimers,
+
+	0x1A: GroupDeck, 0x1B: GroupDeck, 0x41: GroupDeck, 0x42: GroupDeck,
+
+	0x05: GroupTuner, 0x06: GroupTuner, 0x07: GroupTuner, 0x08: GroupTuner,
+	0x92: GroupTuner, 0x93: GroupTuner,
+
+	0x87: GroupVendor, 0x8C: GroupVendor, 0x89: GroupVendor, 0xA0: GroupVendor,
+	0x8A: GroupVendor, 0x8B: GroupVendor,
+
+	0x64: GroupUserInterface, 0x46: GroupUserInterface,
+	0x47: GroupUserInterface, 0x8D: GroupUserInterface,
+	0x8E: GroupUserInterface, 0x44: GroupUserInterface,
+	0x45: GroupUserInterface,
+}
+
+// defaultKeyDelay - the delay between the keys sent by SendKeys unless
+// changed with SetKeyDelay
+const defaultKeyDelay = 100 * time.Millisecond
+
+// probeInterval - the pause between the requests sent by ProbeCapabilities
+const probeInterval = 100 * time.Millisecond
+
+// probes - requests that ProbeCapabilities can send, with their parameters
+// and the opcode of the answer
+var probes = map[int]struct {
+	params []byte
+	reply  int
+}{
+	0x08: {[]byte{0x03}, 0x07}, // GIVE_TUNER_DEVICE_STATUS (once)
+	0x1A: {[]byte{0x03}, 0x1B}, // GIVE_DECK_STATUS (once)
+	0x46: {nil, 0x47},          // GIVE_OSD_NAME
+	0x71: {nil, 0x7A},          // GIVE_AUDIO_STATUS
+	0x7D: {nil, 0x7E},          // GIVE_SYSTEM_AUDIO_MODE_STATUS
+	0x83: {nil, 0x84},          // GIVE_PHYSICAL_ADDRESS
+	0x8C: {nil, 0x87},          // GIVE_DEVICE_VENDOR_ID
+	0x8F: {nil, 0x90},          // GIVE_DEVICE_POWER_STATUS
+	0x91: {nil, 0x32},          // GET_MENU_LANGUAGE
+	0x9F: {nil, 0x9E},          // GET_CEC_VERSION
+}
+
+// openRetryDelay - the pause before the first retry of OpenWithOptions, it
+// doubles for every further retry
+const openRetryDelay = 500 * time.Millisecond
+
+// routeSettleTimeout - how long BecomeActiveSource waits for the TV to
+// switch its input
+const routeSettleTimeout = 2 * time.Second
+
+// replyTimeout - how long to wait for a device to reply to a request
+const replyTimeout = time.Second
+
+// powerPollInterval - how often WaitForPowerState asks for the power status
+const powerPollInterval = 500 * time.Millisecond
+
+// Logical addresses, the index into the table of logical address names
+const (
+	AddrTV           = 0
+	AddrRecording1   = 1
+	AddrRecording2   = 2
+	AddrTuner1       = 3
+	AddrPlayback1    = 4
+	AddrAudioSystem  = 5
+	AddrTuner2       = 6
+	AddrTuner3       = 7
+	AddrPlayback2    = 8
+	AddrRecording3   = 9
+	AddrTuner4       = 10
+	AddrPlayback3    = 11
+	AddrReserved1    = 12
+	AddrReserved2    = 13
+	AddrFreeUse      = 14
+	AddrBroadcast    = 15
+	AddrUnregistered = 15
+)
+
 var logicalNames = []string{"TV", "Recording", "Recording2", "Tuner",
 	"Playback", "Audio", "Tuner2", "Tuner3",
 	"Playback2", "Recording3", "Tuner4", "Playback3",
 	"Reserved", "Reserved2", "Free", "Broadcast"}
 
+var vendorMu sync.RWMutex
+
 var vendorList = map[uint64]string{0x000039: "Toshiba", 0x0000F0: "Samsung",
 	0x0005CD: "Denon", 0x000678: "Marantz", 0x000982: "Loewe", 0x0009B0: "Onkyo",
 	0x000CB8: "Medion", 0x000CE7: "Toshiba", 0x001582: "Pulse Eight",
@@ -43,6 +669,24 @@ var vendorList = map[uint64]string{0x000039: "Toshiba", 0x0000F0: "Samsung",
 	0x18C086: "Broadcom", 0x6B746D: "Vizio", 0x8065E9: "Benq",
 	0x9C645E: "Harman Kardon"}
 
+var cecVersions = map[int]string{0x01: "1.2", 0x02: "1.2a", 0x03: "1.3",
+	0x04: "1.3a", 0x05: "1.4", 0x06: "2.0"}
+
+// minCECVersions - opcodes that were added after CEC 1.2, with the version
+// that introduced them
+var minCECVersions = map[int]CECVersion{
+	0x33: CECVersion13a, 0x34: CECVersion13a, 0x35: CECVersion13a,
+	0x43: CECVersion13a, 0x97: CECVersion13a, 0x99: CECVersion13a,
+	0x9D: CECVersion13a,
+	0x9E: CECVersion13a, 0x9F: CECVersion13a, 0xA0: CECVersion13a,
+	0xA1: CECVersion13a, 0xA2: CECVersion13a,
+	0x9A: CECVersion14, 0xA3: CECVersion14, 0xA4: CECVersion14,
+	0xC0: CECVersion14, 0xC1: CECVersion14, 0xC2: CECVersion14,
+	0xC3: CECVersion14, 0xC4: CECVersion14, 0xC5: CECVersion14,
+	0xF8: CECVersion14,
+	0xA5: CECVersion20, 0xA6: CECVersion20, 0xA7: CECVersion20,
+	0xA8: CECVersion20}
+
 var opcodes = map[int]string{
 	0x82: "ACTIVE_SOURCE",
 	0x04: "IMAGE_VIEW_ON",
@@ -119,6 +763,10 @@ var opcodes = map[int]string{
 	0xFD: "NONE",
 }
 
+var abortReasons = map[int]string{0x00: "unrecognized opcode",
+	0x01: "not in correct mode to respond", 0x02: "cannot provide source",
+	0x03: "invalid operand", 0x04: "refused", 0x05: "unable to determine"}
+
 var keyList = map[int]string{0x00: "Select", 0x01: "Up", 0x02: "Down", 0x03: "Left",
 	0x04: "Right", 0x05: "RightUp", 0x06: "RightDown", 0x07: "LeftUp",
 	0x08: "LeftDown", 0x09: "RootMenu", 0x0A: "SetupMenu", 0x0B: "ContentsMenu",
@@ -135,178 +783,1634 @@ var keyList = map[int]string{0x00: "Select", 0x01: "Up", 0x02: "Down", 0x03: "Le
 	0x53: "ElectronicProgramGuide", 0x54: "TimerProgramming",
 	0x55: "InitialConfiguration", 0x60: "PlayFunction", 0x61: "PausePlay",
 	0x62: "RecordFunction", 0x63: "PauseRecordFunction",
-	0x64: "StopFunction", 0x65: "Mute",
+	0x64: "StopFunction", 0x65: "MuteFunction",
 	0x66: "RestoreVolume", 0x67: "Tune", 0x68: "SelectMedia",
 	0x69: "SelectAvInput", 0x6A: "SelectAudioInput", 0x6B: "PowerToggle",
-	0x6C: "PowerOff", 0x6D: "PowerOn", 0x71: "Blue", 0X72: "Red", 0x73: "Green",
+	0x6C: "PowerOff", 0x6D: "PowerOn", 0x71: "Blue", 0x72: "Red", 0x73: "Green",
 	0x74: "Yellow", 0x75: "F5", 0x76: "Data", 0x91: "AnReturn",
 	0x96: "Max"}
 
 // Open - open a new connection to the CEC device with the given name
 func Open(name string, deviceName string) (*Connection, error) {
-	c := new(Connection)
+	return OpenWithOptions(name, OpenOptions{DeviceName: deviceName})
+}
 
-	var err error
+// OpenContext - same as Open, but gives up when the context is done. A
+// wedged adapter can't be interrupted, so if it eventually opens after the
+// context is done the connection is closed again in the background
+func OpenContext(ctx context.Context, name string, deviceName string) (*Connection, error) {
+	type result struct {
+		conn *Connection
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := Open(name, deviceName)
+		done <- result{conn, err}
+	}()
 
-	c.connection, err = cecInit(c, deviceName)
-	if err != nil {
-		log.Println(err)
-		return nil, err
+	select {
+	case res := <-done:
+		return res.conn, res.err
+	case <-ctx.Done():
+		go func() {
+			if res := <-done; res.conn != nil {
+				res.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
 	}
+}
+
+// OpenWithOptions - open a new connection to the CEC device with the given
+// name and configure it with the given options
+func OpenWithOptions(name string, opts OpenOptions) (*Connection, error) {
+	c := new(Connection)
+	c.adapterName = name
+	c.options = opts
+	c.logger = opts.Logger
+	c.logLevel = opts.LogLevel
 
-	adapter, err := getAdapter(c.connection, name)
+	var adapter cecAdapter
+	var err error
+	for attempt, delay := 0, openRetryDelay; ; attempt, delay = attempt+1, delay*2 {
+		err = openSteps(c.destroy,
+			func() (err error) {
+				c.connection, err = cecInit(c, opts)
+				return err
+			},
+			func() (err error) {
+				adapter, err = getAdapter(c.connection, name)
+				return err
+			},
+			func() error {
+				return openAdapter(c.connection, adapter, opts.ConnectTimeout)
+			})
+		if err == nil || attempt >= opts.OpenRetries {
+			break
+		}
+		c.logf("cec open failed, retrying in %v: %v", delay, err)
+		time.Sleep(delay)
+	}
 	if err != nil {
-		log.Println(err)
+		c.logf("%v", err)
 		return nil, err
 	}
 
-	err = openAdapter(c.connection, adapter)
+	return c, nil
+}
+
+// OpenAdapter - open a new connection to the given adapter (as returned by
+// ListAdapters) with the given device name
+func OpenAdapter(info AdapterInfo, deviceName string) (*Connection, error) {
+	c := new(Connection)
+	c.adapterName = info.Comm
+	c.options = OpenOptions{DeviceName: deviceName}
+
+	err := openSteps(c.destroy,
+		func() (err error) {
+			c.connection, err = cecInit(c, c.options)
+			return err
+		},
+		func() error {
+			return openAdapter(c.connection, cecAdapter{Path: info.Path, Comm: info.Comm}, 0)
+		})
 	if err != nil {
-		log.Println(err)
+		c.logf("%v", err)
 		return nil, err
 	}
 
 	return c, nil
 }
 
+// openSteps - run the steps that open a connection in order, the first one
+// creates the libcec connection. If a later step fails, the connection is
+// destroyed before the error is returned so it doesn't leak
+func openSteps(destroy func(), steps ...func() error) error {
+	for i, step := range steps {
+		if err := step(); err != nil {
+			if i > 0 {
+				destroy()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
 // Key - send key press and release commands (hold key for 10ms) to the device
 // at the given address, the key code can be specified as a hex-code or by
 // its name
-func (c *Connection) Key(address int, key interface{}) {
-	var keycode int
+func (c *Connection) Key(address int, key interface{}) error {
+	return c.KeyWithDuration(address, key, 10*time.Millisecond)
+}
 
-	switch key := key.(type) {
-	case string:
-		if key[:2] == "0x" && len(key) == 4 {
-			keybytes, err := hex.DecodeString(key[2:])
-			if err != nil {
-				log.Println(err)
-				return
-			}
-			keycode = int(keybytes[0])
-		} else {
-			keycode = GetKeyCodeByName(key)
-		}
-	case int:
-		keycode = key
-	default:
-		log.Println("Invalid key type")
-		return
+// KeyWithDuration - send key press and release commands to the device at
+// the given address and hold the key for the given duration in between. A
+// zero duration sends the press and release back-to-back
+func (c *Connection) KeyWithDuration(address int, key interface{}, hold time.Duration) error {
+	err := c.KeyDown(address, key)
+	if err != nil {
+		return err
 	}
-	er := c.KeyPress(address, keycode)
-	if er != nil {
-		log.Println(er)
-		return
+	if hold > 0 {
+		time.Sleep(hold)
 	}
-	time.Sleep(10 * time.Millisecond)
-	er = c.KeyRelease(address)
-	if er != nil {
-		log.Println(er)
-		return
+	err = c.KeyUp(address)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrKeyHeld, err)
 	}
+	return nil
 }
 
-func (c *Connection) commandReceived(msg *Command) {
-	log.Printf("cec command: %x = %s", msg.opcode, opcodes[msg.opcode])
-
-	if c.Commands != nil {
-		c.Commands <- msg
+// KeyDown - send a key press command to the device at the given address
+// without releasing it, the key is specified like for Key. Devices repeat
+// the key's action until it is released, so the caller is responsible for
+// calling KeyUp eventually
+func (c *Connection) KeyDown(address int, key interface{}) error {
+	keycode, err := resolveKey(key)
+	if err != nil {
+		return err
 	}
+	return c.KeyPress(address, keycode)
 }
 
-func (c *Connection) messageReceived(msg string) {
-	if c.Messages != nil {
-		c.Messages <- msg
-	}
+// KeyUp - release the key that is held down on the device at the given
+// address
+func (c *Connection) KeyUp(address int) error {
+	return c.KeyRelease(address)
 }
 
-func (c *Connection) keyPressed(k int) {
-	log.Printf("cec key pressed: %d", k)
-
-	if c.KeyPresses != nil {
-		c.KeyPresses <- k
+// SendKeys - press and release a sequence of keys separated by whitespace
+// (e.g. "1 2 3 Select") on the device at the given address, with the delay
+// set by SetKeyDelay between the keys. Keys are specified like for Key, the
+// sequence isn't sent if one of them can't be resolved
+func (c *Connection) SendKeys(address int, keys string) error {
+	tokens := strings.Fields(keys)
+	codes := make([]int, len(tokens))
+	for i, token := range tokens {
+		keycode, err := resolveKey(token)
+		if err != nil {
+			return fmt.Errorf("key %q: %w", token, err)
+		}
+		codes[i] = keycode
 	}
-}
-
-// List - list active devices (returns a map of Devices)
-func (c *Connection) List() map[string]Device {
-	devices := make(map[string]Device)
-
-	activeDevices := c.GetActiveDevices()
-
-	for address, active := range activeDevices {
-		if active {
-			var dev Device
 
-			dev.LogicalAddress = address
-			dev.PhysicalAddress = c.GetDevicePhysicalAddress(address)
-			dev.OSDName = c.GetDeviceOSDName(address)
-			dev.PowerStatus = c.GetDevicePowerStatus(address)
-			dev.ActiveSource = c.IsActiveSource(address)
-			dev.Vendor = GetVendorByID(c.GetDeviceVendorID(address))
+	c.mu.Lock()
+	delay := c.keyDelay
+	if !c.keyDelaySet {
+		delay = defaultKeyDelay
+	}
+	c.mu.Unlock()
 
-			devices[logicalNames[address]] = dev
+	for i, keycode := range codes {
+		if i > 0 && delay > 0 {
+			time.Sleep(delay)
+		}
+		err := c.Key(address, keycode)
+		if err != nil {
+			return fmt.Errorf("key %q: %w", tokens[i], err)
 		}
 	}
-	return devices
+	return nil
 }
 
-// removeSeparators - remove separators (":", "-", " ", "_")
-func removeSeparators(in string) string {
-	out := strings.Map(func(r rune) rune {
-		if strings.IndexRune(":-_ ", r) < 0 {
-			return r
-		}
-		return -1
-	}, in)
+// SetKeyDelay - set the delay between the keys sent by SendKeys, the
+// default is 100ms
+func (c *Connection) SetKeyDelay(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	return (out)
+	c.keyDelay = d
+	c.keyDelaySet = true
 }
 
-// GetKeyCodeByName - get the keycode by its name
-func GetKeyCodeByName(name string) int {
-	name = removeSeparators(name)
-	name = strings.ToLower(name)
+// RampKey - send count presses of the key to the device at the given
+// address, spaced by interval, and a single release after the last one (e.g.
+// to ramp up the volume at a steady rate). Returns on the first press that
+// fails
+func (c *Connection) RampKey(address int, key interface{}, count int, interval time.Duration) error {
+	if count < 1 {
+		return fmt.Errorf("Invalid key count %d", count)
+	}
+	keycode, err := resolveKey(key)
+	if err != nil {
+		return err
+	}
 
-	for code, value := range keyList {
-		if strings.ToLower(value) == name {
-			return code
+	for i := 0; i < count; i++ {
+		if i > 0 && interval > 0 {
+			time.Sleep(interval)
+		}
+		if err := c.KeyPress(address, keycode); err != nil {
+			return err
 		}
 	}
 
-	return -1
+	if err := c.KeyUp(address); err != nil {
+		return fmt.Errorf("%w: %w", ErrKeyHeld, err)
+	}
+	return nil
 }
 
-// GetLogicalAddressByName - get logical address by its name
-func GetLogicalAddressByName(name string) int {
-	name = removeSeparators(name)
-	l := len(name)
-
-	if name[l-1] == '1' {
-		name = name[:l-1]
+// resolveKey - get the key code of a key given as a key code, a hex-code
+// string or a key name
+func resolveKey(key interface{}) (int, error) {
+	switch key := key.(type) {
+	case string:
+		// anything that isn't a valid 0xNN code is looked up as a name
+		if len(key) == 4 && strings.HasPrefix(key, "0x") {
+			if keybytes, err := hex.DecodeString(key[2:]); err == nil {
+				return int(keybytes[0]), nil
+			}
+		}
+		keycode := GetKeyCodeByName(key)
+		if keycode < 0 {
+			return -1, ErrUnknownKey
+		}
+		return keycode, nil
+	case int:
+		return key, nil
+	default:
+		return -1, ErrInvalidKey
 	}
+}
 
-	name = strings.ToLower(name)
+func (c *Connection) commandReceived(msg *Command) {
+	c.logf("cec command: %x = %s", msg.opcode, opcodes[msg.opcode])
 
-	for i := 0; i < 16; i++ {
-		if strings.ToLower(logicalNames[i]) == name {
-			return i
-		}
+	// Close sets closed under mu before closing the channels, so holding mu
+	// for the sends below keeps them from racing with Close
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
 	}
+	c.commandsReceived.Add(1)
 
-	if name == "unregistered" {
-		return 15
+	// a slow reader must not stall the libcec callback thread, so commands
+	// are dropped when a channel isn't ready
+	if c.Commands != nil {
+		select {
+		case c.Commands <- msg:
+		default:
+			c.droppedCommands.Add(1)
+		}
 	}
 
-	return -1
-}
+	c.invalidateCache(msg)
+	if fn := c.onCommand; fn != nil {
+		c.dispatch(func() { fn(msg) })
+	}
+	if r := c.responder; r != nil && msg.opcode_set == 1 && msg.destination != AddrBroadcast {
+		// the reply is transmitted from the handler goroutine, transmitting
+		// on the libcec callback thread could deadlock with Close
+		c.dispatch(func() { c.respond(r, msg) })
+	}
+	for _, sub := range c.subscriptions {
+		if sub.matches(msg.opcode) {
+			select {
+			case sub.ch <- msg:
+			default:
+				c.droppedCommands.Add(1)
+			}
+		}
+	}
+
+	if msg.opcode == 0x90 && len(msg.parameters) > 0 && c.PowerEvents != nil {
+		select {
+		case c.PowerEvents <- PowerEvent{Address: int(msg.initiator), Status: decodePowerStatus(msg.parameters[0])}:
+		default:
+		}
+	}
+
+	if c.RoutingEvents != nil {
+		if ev, ok := routingEvent(msg); ok {
+			select {
+			case c.RoutingEvents <- ev:
+			default:
+			}
+		}
+	}
+
+	if c.AbortEvents != nil {
+		if ev, ok := abortEvent(msg); ok {
+			select {
+			case c.AbortEvents <- ev:
+			default:
+			}
+		}
+	}
+}
+
+// abortEvent - decode a FEATURE_ABORT or ABORT command
+func abortEvent(msg *Command) (AbortEvent, bool) {
+	ev := AbortEvent{From: int(msg.initiator), To: int(msg.destination), Opcode: -1}
+	if msg.opcode_set == 1 && msg.opcode == 0xFF {
+		return ev, true
+	}
+	rejected, reason, ok := msg.FeatureAbort()
+	if !ok {
+		return AbortEvent{}, false
+	}
+	ev.Opcode, ev.Reason = rejected, reason
+	return ev, true
+}
+
+// routingEvent - decode a ROUTING_CHANGE or ROUTING_INFORMATION command
+func routingEvent(msg *Command) (RoutingEvent, bool) {
+	p := msg.parameters
+	switch {
+	case msg.opcode == 0x80 && len(p) >= 4:
+		return RoutingEvent{
+			From: uint16(p[0])<<8 | uint16(p[1]),
+			To:   uint16(p[2])<<8 | uint16(p[3]),
+		}, true
+	case msg.opcode == 0x81 && len(p) >= 2:
+		return RoutingEvent{From: 0xFFFF, To: uint16(p[0])<<8 | uint16(p[1])}, true
+	}
+	return RoutingEvent{}, false
+}
+
+// NewTestConnection - create a connection without an adapter for testing
+// command handlers, commands are fed into it with InjectCommand
+func NewTestConnection() *Connection {
+	return &Connection{
+		test: true,
+		// there's no bus, transmitted commands are dropped
+		send:                func(cmd *Command) error { return nil },
+		testAddress:         AddrPlayback1,
+		testPhysicalAddress: 0x1000,
+	}
+}
+
+// SetTestAddress - set the logical and physical address a connection
+// created by NewTestConnection reports as its own (Playback 1 at 1.0.0.0 by
+// default)
+func (c *Connection) SetTestAddress(logical int, physical uint16) {
+	if !c.test {
+		panic("cec: SetTestAddress requires a connection created by NewTestConnection")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.testAddress = logical
+	c.testPhysicalAddress = physical
+}
+
+// InjectCommand - handle the command as if it was received from the bus,
+// only available on connections created by NewTestConnection. Commands
+// injected after Close are dropped
+func (c *Connection) InjectCommand(cmd *Command) {
+	if !c.test {
+		panic("cec: InjectCommand requires a connection created by NewTestConnection")
+	}
+	if cmd.Operation == "" && cmd.opcode_set == 1 {
+		cmd.Operation = opcodes[cmd.opcode]
+	}
+	c.commandReceived(cmd)
+}
+
+// Stats - counters of a Connection, see Connection.Stats
+type Stats struct {
+	CommandsReceived    uint64
+	CommandsTransmitted uint64
+	TransmitFailures    uint64
+	DroppedCommands     uint64
+	Reconnections       uint64
+}
+
+// Stats - get a snapshot of the connection's counters. Transmitted commands
+// and failures count the frames sent by Transmit and by the methods using
+// libcec's helpers (keys, standby, OSD strings, active source and stream
+// path), not the requests libcec sends itself to update its device details
+func (c *Connection) Stats() Stats {
+	return Stats{
+		CommandsReceived:    c.commandsReceived.Load(),
+		CommandsTransmitted: c.commandsTransmitted.Load(),
+		TransmitFailures:    c.transmitFailures.Load(),
+		DroppedCommands:     c.droppedCommands.Load(),
+		Reconnections:       c.reconnections.Load(),
+	}
+}
+
+// DroppedCommands - the number of received commands that were dropped
+// because the Commands channel or a subscription channel wasn't ready
+func (c *Connection) DroppedCommands() uint64 {
+	return c.droppedCommands.Load()
+}
+
+type subscription struct {
+	opcodes map[int]bool
+	ch      chan *Command
+}
+
+func (sub *subscription) matches(opcode int) bool {
+	return len(sub.opcodes) == 0 || sub.opcodes[opcode]
+}
+
+// SubscribeOpcodes - get a channel that receives the commands with one of
+// the given opcodes, or all commands if no opcode is given. Commands are
+// dropped when the channel is full, it is closed when the connection is
+// closed
+func (c *Connection) SubscribeOpcodes(opcodes ...int) <-chan *Command {
+	return c.subscribe(opcodes...).ch
+}
+
+func (c *Connection) subscribe(opcodes ...int) *subscription {
+	sub := &subscription{
+		opcodes: make(map[int]bool),
+		ch:      make(chan *Command, 16),
+	}
+	for _, opcode := range opcodes {
+		sub.opcodes[opcode] = true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		close(sub.ch)
+	} else {
+		c.subscriptions = append(c.subscriptions, sub)
+	}
+	return sub
+}
+
+// unsubscribe - stop delivering commands to the subscription, its channel
+// is left open
+func (c *Connection) unsubscribe(sub *subscription) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, s := range c.subscriptions {
+		if s == sub {
+			c.subscriptions = append(c.subscriptions[:i], c.subscriptions[i+1:]...)
+			return
+		}
+	}
+}
+
+// WaitFor - wait for the next command with the given opcode from the given
+// logical address (-1 for any address). Returns ErrTimeout when the
+// context's deadline passes first, or the context's error when it is
+// cancelled
+func (c *Connection) WaitFor(ctx context.Context, opcode int, from int) (*Command, error) {
+	if err := c.begin(); err != nil {
+		return nil, err
+	}
+	defer c.inflight.Done()
+
+	sub := c.subscribe(opcode)
+	defer c.unsubscribe(sub)
+
+	return c.wait(ctx, sub, from, -1)
+}
+
+// request - transmit a command to the destination and wait for its reply
+// with the given opcode. A FEATURE_ABORT for the command is returned as an
+// error, ErrDeviceNotPresent if the destination doesn't acknowledge it
+func (c *Connection) request(destination, opcode int, params []byte, reply int) (*Command, error) {
+	if err := c.begin(); err != nil {
+		return nil, err
+	}
+	defer c.inflight.Done()
+
+	// subscribe before transmitting, a fast reply would be missed otherwise
+	sub := c.subscribe(reply, 0x00)
+	defer c.unsubscribe(sub)
+
+	err := c.transmitFromSelf(destination, opcode, params)
+	if errors.Is(err, ErrNotACKed) && destination != AddrBroadcast {
+		return nil, fmt.Errorf("%w: %s", ErrDeviceNotPresent, GetLogicalNameByAddress(destination))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), replyTimeout)
+	defer cancel()
+
+	return c.wait(ctx, sub, destination, opcode)
+}
+
+// BecomeActiveSource - wake up the TV and make it switch to this adapter:
+// IMAGE_VIEW_ON is sent to the TV first, then ACTIVE_SOURCE is broadcast
+// with our physical address. Waits up to two seconds for the TV to report
+// the route to our address, but not all TVs do
+func (c *Connection) BecomeActiveSource() error {
+	if err := c.begin(); err != nil {
+		return err
+	}
+	defer c.inflight.Done()
+
+	addr, err := c.MyPhysicalAddress()
+	if err != nil {
+		return err
+	}
+
+	sub := c.subscribe(0x80, 0x81)
+	defer c.unsubscribe(sub)
+
+	err = c.transmitFromSelf(AddrTV, 0x04, nil)
+	if err != nil {
+		return err
+	}
+	err = c.SetActiveSource()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), routeSettleTimeout)
+	defer cancel()
+
+	for {
+		cmd, err := c.wait(ctx, sub, AddrTV, -1)
+		if err == ErrTimeout {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if ev, ok := routingEvent(cmd); ok && ev.To == addr {
+			return nil
+		}
+	}
+}
+
+// RequestActiveSource - ask the bus which device is the active source and
+// wait until the context is done (or for a second) for the answer. The
+// active source announces its physical address, the logical address is the
+// one it sent the announcement from
+func (c *Connection) RequestActiveSource(ctx context.Context) (physicalAddr uint16, logicalAddr int, err error) {
+	if err := c.begin(); err != nil {
+		return 0, 0, err
+	}
+	defer c.inflight.Done()
+
+	sub := c.subscribe(0x82)
+	defer c.unsubscribe(sub)
+
+	err = c.transmitFromSelf(AddrBroadcast, 0x85, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, replyTimeout)
+	defer cancel()
+
+	for {
+		cmd, err := c.wait(ctx, sub, -1, -1)
+		if err != nil {
+			return 0, 0, err
+		}
+		if p := cmd.Parameters(); len(p) >= 2 {
+			return uint16(p[0])<<8 | uint16(p[1]), cmd.Initiator(), nil
+		}
+	}
+}
+
+// wait - return the first command on the subscription from the given
+// address (-1 for any address). If request isn't -1, a FEATURE_ABORT of
+// that opcode is returned as an error and other FEATURE_ABORTs are skipped
+func (c *Connection) wait(ctx context.Context, sub *subscription, from int, request int) (*Command, error) {
+	for {
+		select {
+		case cmd, ok := <-sub.ch:
+			if !ok {
+				return nil, ErrClosed
+			}
+			if from >= 0 && cmd.Initiator() != from {
+				continue
+			}
+			if request >= 0 {
+				if rejected, reason, ok := cmd.FeatureAbort(); ok {
+					if rejected == request {
+						return nil, fmt.Errorf("%s rejected: %s", opcodes[request], reason)
+					}
+					continue
+				}
+			}
+			return cmd, nil
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, ErrTimeout
+			}
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (c *Connection) messageReceived(msg LogMessage) {
+	// a slow reader must not stall the libcec callback thread
+	if c.Messages != nil {
+		select {
+		case c.Messages <- msg.Text:
+		default:
+		}
+	}
+	if c.LogMessages != nil {
+		select {
+		case c.LogMessages <- msg:
+		default:
+		}
+	}
+
+	c.mu.Lock()
+	if fn := c.onLogMessage; fn != nil {
+		c.dispatch(func() { fn(msg) })
+	}
+	c.mu.Unlock()
+}
+
+func (c *Connection) alertReceived(alert Alert) {
+	// a slow reader must not stall the libcec callback thread
+	if c.Alerts != nil {
+		select {
+		case c.Alerts <- alert:
+		default:
+		}
+	}
+}
+
+func (c *Connection) keyPressed(k int, duration time.Duration) {
+	c.logf("cec key pressed: %d", k)
+
+	// a slow reader must not stall the libcec callback thread
+	if c.KeyPresses != nil {
+		select {
+		case c.KeyPresses <- k:
+		default:
+		}
+	}
+	ev := KeyEvent{Code: k, Name: keyList[k], Duration: duration}
+	if c.KeyEvents != nil {
+		select {
+		case c.KeyEvents <- ev:
+		default:
+		}
+	}
+
+	c.mu.Lock()
+	if fn := c.onKeyPress; fn != nil {
+		c.dispatch(func() { fn(ev) })
+	}
+	c.mu.Unlock()
+}
+
+// OnCommand - call fn for every received command, in addition to the
+// delivery on the Commands channel. Like OnKeyPress and OnLogMessage, fn is
+// called on a separate goroutine, one event at a time, and events are
+// dropped while more than 64 are waiting for their handlers. A nil fn
+// removes the handler
+func (c *Connection) OnCommand(fn func(*Command)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.onCommand = fn
+	c.startHandlers()
+}
+
+// OnKeyPress - call fn for every key event, see OnCommand
+func (c *Connection) OnKeyPress(fn func(KeyEvent)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.onKeyPress = fn
+	c.startHandlers()
+}
+
+// OnLogMessage - call fn for every libcec log message, see OnCommand
+func (c *Connection) OnLogMessage(fn func(LogMessage)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.onLogMessage = fn
+	c.startHandlers()
+}
+
+// Responder - answers the requests other devices send to this adapter, see
+// SetResponder
+type Responder interface {
+	PowerStatus() PowerStatus
+	OSDName() string
+	VendorID() uint64
+}
+
+// SetResponder - answer GIVE_DEVICE_POWER_STATUS, GIVE_OSD_NAME and
+// GIVE_DEVICE_VENDOR_ID requests with the values returned by r, which is
+// called from the same goroutine as the OnCommand handlers. libcec answers
+// some of these requests for its own addresses as well (e.g. GIVE_OSD_NAME
+// with the configured device name), so keep its configuration consistent
+// with r. Pass nil to stop answering
+func (c *Connection) SetResponder(r Responder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.responder = r
+	c.startHandlers()
+}
+
+// respond - transmit the reply to a request using the responder
+func (c *Connection) respond(r Responder, msg *Command) {
+	self, from := int(msg.destination), int(msg.initiator)
+
+	var reply *Command
+	switch msg.opcode {
+	case 0x8F:
+		reply = NewCommand(self, from, 0x90, []byte{byte(r.PowerStatus())})
+	case 0x46:
+		name := r.OSDName()
+		if len(name) > 14 {
+			name = name[:14]
+		}
+		reply = NewCommand(self, from, 0x47, []byte(name))
+	case 0x8C:
+		id := r.VendorID()
+		reply = NewCommand(self, AddrBroadcast, 0x87, []byte{byte(id >> 16), byte(id >> 8), byte(id)})
+	default:
+		return
+	}
+
+	if err := c.transmit(reply); err != nil {
+		c.logf("cec responder: %s", err)
+	}
+}
+
+// startHandlers - start the goroutine that calls the handlers, c.mu must be
+// held
+func (c *Connection) startHandlers() {
+	if c.handlers != nil || c.closed {
+		return
+	}
+	c.handlers = make(chan func(), 64)
+	go func(handlers chan func()) {
+		for fn := range handlers {
+			fn()
+		}
+	}(c.handlers)
+}
+
+// dispatch - queue a handler call without blocking, c.mu must be held
+func (c *Connection) dispatch(fn func()) {
+	if c.handlers == nil || c.closed {
+		return
+	}
+	select {
+	case c.handlers <- fn:
+	default:
+	}
+}
+
+// Logger - the interface used for the log output of a connection, it is
+// satisfied by *log.Logger
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// DiscardLogger - a Logger that drops all output. To keep a connection
+// completely quiet, pass it as OpenOptions.Logger (so the output while
+// opening is dropped as well). libcec itself doesn't print anything, its
+// messages are only passed to the connection
+var DiscardLogger Logger = discardLogger{}
+
+type discardLogger struct{}
+
+func (discardLogger) Printf(format string, v ...interface{}) {}
+
+// SetLogger - send the log output of the connection to the given logger
+// instead of the standard logger
+func (c *Connection) SetLogger(l Logger) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.logger = l
+}
+
+// libcec log levels (the values of libcec's cec_log_level bitmask)
+const (
+	LogError   = 1
+	LogWarning = 2
+	LogNotice  = 4
+	LogTraffic = 8
+	LogDebug   = 16
+)
+
+// SetLogLevel - only handle libcec log messages with the given level or
+// above (e.g. LogWarning drops notice, traffic and debug messages). The
+// default is LogNotice
+func (c *Connection) SetLogLevel(level int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.logLevel = level
+}
+
+func (c *Connection) logLevelEnabled(level int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.logLevel == 0 {
+		return level <= LogNotice
+	}
+	return level <= c.logLevel
+}
+
+func (c *Connection) logf(format string, v ...interface{}) {
+	c.mu.Lock()
+	l := c.logger
+	c.mu.Unlock()
+
+	if l == nil {
+		l = log.Default()
+	} else if l == DiscardLogger {
+		return
+	}
+	l.Printf(format, v...)
+}
+
+// GetDevicePowerStatusContext - same as DevicePowerStatus, but returns
+// when the context is done instead of waiting for libcec's timeout
+func (c *Connection) GetDevicePowerStatusContext(ctx context.Context, address int) (PowerStatus, error) {
+	if c.isClosed() {
+		return PowerUnknown, ErrClosed
+	}
+
+	status := PowerUnknown
+	err := withContext(ctx, func() {
+		status = c.DevicePowerStatus(address)
+	})
+	if err != nil {
+		return PowerUnknown, err
+	}
+	return status, nil
+}
+
+// DeviceOSDName - ask the device at the given address for its OSD name.
+// Returns ErrTimeout if the device doesn't answer, together with the last
+// name it reported (if any)
+func (c *Connection) DeviceOSDName(address int) (string, error) {
+	if addresses, err := c.MyLogicalAddresses(); err == nil {
+		for _, addr := range addresses {
+			if addr == address {
+				c.mu.Lock()
+				defer c.mu.Unlock()
+				return c.options.DeviceName, nil
+			}
+		}
+	}
+
+	reply, err := c.request(address, 0x46, nil, 0x47)
+	if err != nil {
+		c.mu.Lock()
+		name := c.osdNames[address]
+		c.mu.Unlock()
+		return name, err
+	}
+
+	name := string(reply.Parameters())
+
+	c.mu.Lock()
+	if c.osdNames == nil {
+		c.osdNames = make(map[int]string)
+	}
+	c.osdNames[address] = name
+	c.mu.Unlock()
+
+	return name, nil
+}
+
+// DeviceSupports - check if the CEC version reported by the device at the
+// given address is recent enough for the opcode. Opcodes from CEC 1.2 are
+// always supported
+func (c *Connection) DeviceSupports(address int, opcode int) (bool, error) {
+	min, ok := minCECVersions[opcode]
+	if !ok {
+		return true, nil
+	}
+	version, err := c.DeviceCECVersion(address)
+	if err != nil {
+		return false, err
+	}
+	return version.AtLeast(min), nil
+}
+
+// ProbeCapabilities - send the given requests (e.g. 0x1A GIVE_DECK_STATUS)
+// to the device at the given address one by one and report which of them it
+// answered. Requests that were aborted or not answered are reported as
+// false. Only requests with a well-known answer can be probed
+func (c *Connection) ProbeCapabilities(ctx context.Context, address int, opcodes []int) (map[int]bool, error) {
+	for _, opcode := range opcodes {
+		if _, ok := probes[opcode]; !ok {
+			return nil, fmt.Errorf("Can't probe opcode 0x%02x", opcode)
+		}
+	}
+
+	supported := make(map[int]bool)
+	for i, opcode := range opcodes {
+		// don't flood the bus
+		if i > 0 {
+			select {
+			case <-time.After(probeInterval):
+			case <-ctx.Done():
+				return supported, ctx.Err()
+			}
+		}
+
+		probe := probes[opcode]
+		_, err := c.request(address, opcode, probe.params, probe.reply)
+		if err == ErrClosed {
+			return supported, err
+		}
+		supported[opcode] = err == nil
+	}
+	return supported, nil
+}
+
+// PowerStatusAll - ask all active devices for their power status at once
+// and collect the replies. Each device has a second to answer (or less if
+// the context is done earlier), devices that don't answer are reported as
+// PowerUnknown
+func (c *Connection) PowerStatusAll(ctx context.Context) (map[int]PowerStatus, error) {
+	if err := c.begin(); err != nil {
+		return nil, err
+	}
+	defer c.inflight.Done()
+
+	sub := c.subscribe(0x90)
+	defer c.unsubscribe(sub)
+
+	own := make(map[int]bool)
+	if addresses, err := c.MyLogicalAddresses(); err == nil {
+		for _, addr := range addresses {
+			own[addr] = true
+		}
+	}
+
+	// the requests are transmitted one after the other, so every device
+	// gets its own deadline counted from its request
+	statuses := make(map[int]PowerStatus)
+	pending := make(map[int]time.Time)
+	for addr, active := range c.GetActiveDevices() {
+		if !active || own[addr] {
+			continue
+		}
+		statuses[addr] = PowerUnknown
+		if err := c.transmitFromSelf(addr, 0x8F, nil); err != nil {
+			c.logf("cec power status request to %d failed: %v", addr, err)
+			continue
+		}
+		pending[addr] = time.Now().Add(replyTimeout)
+	}
+
+	for len(pending) > 0 {
+		var next time.Time
+		for _, deadline := range pending {
+			if next.IsZero() || deadline.Before(next) {
+				next = deadline
+			}
+		}
+		timer := time.NewTimer(time.Until(next))
+
+		select {
+		case cmd, ok := <-sub.ch:
+			timer.Stop()
+			if !ok {
+				return nil, ErrClosed
+			}
+			addr := cmd.Initiator()
+			if _, ok := pending[addr]; !ok || len(cmd.Parameters()) == 0 {
+				continue
+			}
+			statuses[addr] = decodePowerStatus(cmd.Parameters()[0])
+			delete(pending, addr)
+		case now := <-timer.C:
+			for addr, deadline := range pending {
+				if !now.Before(deadline) {
+					delete(pending, addr)
+				}
+			}
+		case <-ctx.Done():
+			timer.Stop()
+			return statuses, nil
+		}
+	}
+	return statuses, nil
+}
 
-// GetLogicalNameByAddress - get logical name by address
+// WaitForPowerState - wait until the device at the given address reports
+// the target power status (e.g. until the TV is on after PowerOn), asking it
+// for its status periodically. Only the exact status counts, a TV in
+// PowerTransitionToOn doesn't satisfy a wait for PowerOn. Returns ErrTimeout
+// when the context's deadline passes first, or the context's error when it
+// is cancelled
+func (c *Connection) WaitForPowerState(ctx context.Context, address int, target PowerStatus) error {
+	if err := c.begin(); err != nil {
+		return err
+	}
+	defer c.inflight.Done()
+
+	sub := c.subscribe(0x90)
+	defer c.unsubscribe(sub)
+
+	poll := func() {
+		if err := c.transmitFromSelf(address, 0x8F, nil); err != nil {
+			c.logf("cec power status request to %d failed: %v", address, err)
+		}
+	}
+
+	ticker := time.NewTicker(powerPollInterval)
+	defer ticker.Stop()
+
+	poll()
+	for {
+		select {
+		case cmd, ok := <-sub.ch:
+			if !ok {
+				return ErrClosed
+			}
+			if cmd.Initiator() != address || len(cmd.Parameters()) == 0 {
+				continue
+			}
+			if decodePowerStatus(cmd.Parameters()[0]) == target {
+				return nil
+			}
+		case <-ticker.C:
+			poll()
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return ErrTimeout
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+// decodePowerStatus - convert a CEC power status operand, values that
+// aren't defined by the spec are PowerUnknown
+func decodePowerStatus(b byte) PowerStatus {
+	status := PowerStatus(b)
+	if status > PowerTransitionToStandby {
+		return PowerUnknown
+	}
+	return status
+}
+
+// AudioStatusContext - same as AudioStatus, but returns when the context is
+// done instead of waiting for libcec's timeout
+func (c *Connection) AudioStatusContext(ctx context.Context) (volume int, muted bool, err error) {
+	var (
+		v         int
+		m         bool
+		statusErr error
+	)
+	err = withContext(ctx, func() {
+		v, m, statusErr = c.AudioStatus()
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return v, m, statusErr
+}
+
+// AudioSystemStatus - get the system audio mode and the volume of the amp
+// at once. libcec handles one request at a time, so they are queried one
+// after the other
+func (c *Connection) AudioSystemStatus(ctx context.Context) (AudioStatus, error) {
+	var (
+		status   AudioStatus
+		queryErr error
+	)
+	err := withContext(ctx, func() {
+		status.SystemAudioMode, queryErr = c.SystemAudioMode()
+		if queryErr != nil {
+			return
+		}
+		status.Volume, status.Muted, queryErr = c.AudioStatus()
+	})
+	if err != nil {
+		return AudioStatus{}, err
+	}
+	if queryErr != nil {
+		return AudioStatus{}, queryErr
+	}
+	return status, nil
+}
+
+// withContext - run fn and return early with the context's error when the
+// context is done first. libcec calls can't be interrupted, so fn keeps
+// running in the background in that case
+func withContext(ctx context.Context, fn func()) error {
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// List - list active devices (returns a map of Devices)
+func (c *Connection) List() map[string]Device {
+	devices := make(map[string]Device)
+
+	scanned, _ := c.Scan()
+	for _, dev := range scanned {
+		devices[GetLogicalNameByAddress(dev.LogicalAddress)] = dev
+	}
+	return devices
+}
+
+// Scan - list active devices ordered by their logical address. The details
+// are the ones libcec has cached, the devices aren't asked for them, use
+// DeviceOSDName or PowerStatusAll to query the bus
+func (c *Connection) Scan() ([]Device, error) {
+	if c.isClosed() {
+		return nil, ErrClosed
+	}
+
+	var devices []Device
+
+	activeDevices := c.GetActiveDevices()
+
+	for address, active := range activeDevices {
+		if active {
+			if dev, ok := c.cachedDevice(address); ok {
+				devices = append(devices, dev)
+				continue
+			}
+
+			var dev Device
+
+			dev.LogicalAddress = address
+			dev.PhysicalAddress = c.GetDevicePhysicalAddress(address)
+			dev.OSDName = c.GetDeviceOSDName(address)
+			dev.PowerStatus = c.DevicePowerStatus(address)
+			dev.ActiveSource = c.IsActiveSource(address)
+			dev.Vendor = GetVendorByID(c.GetDeviceVendorID(address))
+			dev.CECVersion, _ = c.GetCECVersion(address)
+
+			c.cacheDevice(dev)
+			devices = append(devices, dev)
+		}
+	}
+
+	// at least this adapter is always active, so an empty bus means the
+	// scan failed
+	if len(devices) == 0 {
+		return nil, errors.New("No active devices found")
+	}
+	return devices, nil
+}
+
+type cachedDevice struct {
+	device  Device
+	expires time.Time
+}
+
+// FindByOSDName - find the active device with the given OSD name (e.g.
+// "Apple TV"), ignoring case and surrounding whitespace
+func (c *Connection) FindByOSDName(name string) (Device, bool, error) {
+	devices, err := c.Scan()
+	if err != nil {
+		return Device{}, false, err
+	}
+
+	name = strings.TrimSpace(name)
+	for _, dev := range devices {
+		if strings.EqualFold(strings.TrimSpace(dev.OSDName), name) {
+			return dev, true, nil
+		}
+	}
+	return Device{}, false, nil
+}
+
+// SetCacheTTL - cache the device information returned by List and Scan for
+// the given duration, a duration of zero disables the cache. Cached devices
+// are refreshed when they report a change on the bus
+func (c *Connection) SetCacheTTL(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cacheTTL = d
+	c.deviceCache = make(map[int]cachedDevice)
+}
+
+func (c *Connection) cachedDevice(address int) (Device, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.deviceCache[address]
+	if !ok || time.Now().After(cached.expires) {
+		return Device{}, false
+	}
+	return cached.device, true
+}
+
+func (c *Connection) cacheDevice(dev Device) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cacheTTL > 0 {
+		c.deviceCache[dev.LogicalAddress] = cachedDevice{device: dev, expires: time.Now().Add(c.cacheTTL)}
+	}
+}
+
+// invalidateCache - drop the cached devices that are affected by the given
+// command, a vendor announced with DEVICE_VENDOR_ID is stored in the cached
+// device instead. c.mu must be held
+func (c *Connection) invalidateCache(msg *Command) {
+	if id, ok := msg.VendorID(); ok && msg.opcode == 0x87 {
+		if cached, ok := c.deviceCache[int(msg.initiator)]; ok {
+			cached.device.Vendor = GetVendorByID(id)
+			c.deviceCache[int(msg.initiator)] = cached
+		}
+		return
+	}
+
+	switch msg.opcode {
+	case 0x80, 0x82, 0x86, 0x9D:
+		// routing and active source changes affect every device
+		c.deviceCache = make(map[int]cachedDevice)
+	case 0x36:
+		if msg.destination == AddrBroadcast {
+			c.deviceCache = make(map[int]cachedDevice)
+		} else {
+			delete(c.deviceCache, int(msg.destination))
+		}
+	case 0x47, 0x84, 0x87, 0x90, 0x9E:
+		delete(c.deviceCache, int(msg.initiator))
+	}
+}
+
+// VendorID - get the vendor ID carried by a VENDOR_COMMAND_WITH_ID or
+// DEVICE_VENDOR_ID command, ok is false for any other command. A plain
+// VENDOR_COMMAND doesn't carry an ID, its vendor is the one of the initiator
+// (see DeviceVendor)
+func (cmd *Command) VendorID() (uint64, bool) {
+	if cmd.opcode_set != 1 || (cmd.opcode != 0xA0 && cmd.opcode != 0x87) || len(cmd.parameters) < 3 {
+		return 0, false
+	}
+	p := cmd.parameters
+	return uint64(p[0])<<16 | uint64(p[1])<<8 | uint64(p[2]), true
+}
+
+// RecordStatus - decode a RECORD_STATUS command, ok is false for any other
+// command
+func (cmd *Command) RecordStatus() (status RecordStatus, ok bool) {
+	if cmd.opcode_set != 1 || cmd.opcode != 0x0A || len(cmd.parameters) == 0 {
+		return 0, false
+	}
+	return RecordStatus(cmd.parameters[0]), true
+}
+
+// FeatureAbort - decode a FEATURE_ABORT command into the opcode that was
+// rejected and the reason, ok is false for any other command
+func (cmd *Command) FeatureAbort() (rejectedOpcode int, reason string, ok bool) {
+	if cmd.opcode_set != 1 || cmd.opcode != 0x00 || len(cmd.parameters) < 2 {
+		return 0, "", false
+	}
+
+	reason, ok = abortReasons[int(cmd.parameters[1])]
+	if !ok {
+		reason = "unknown"
+	}
+	return int(cmd.parameters[0]), reason, true
+}
+
+// NewCommand - create a command with the given opcode and parameters from
+// the initiator to the destination logical address, without transmitting it
+func NewCommand(initiator, destination, opcode int, params []byte) *Command {
+	return &Command{
+		initiator:   uint32(initiator),
+		destination: uint32(destination),
+		opcode:      opcode,
+		opcode_set:  1,
+		parameters:  params,
+		Operation:   opcodes[opcode],
+	}
+}
+
+// Bytes - the command as it is sent on the bus: the header byte with the
+// initiator and destination, the opcode (unless it is a POLL) and the
+// parameters
+func (cmd *Command) Bytes() []byte {
+	frame := []byte{byte(cmd.initiator&0xF<<4 | cmd.destination&0xF)}
+	if cmd.opcode_set == 1 {
+		frame = append(frame, byte(cmd.opcode))
+	}
+	return append(frame, cmd.parameters...)
+}
+
+// ParseCommand - parse a CEC command encoded as a hex string with
+// separators (e.g. "04:36") into a Command
+func ParseCommand(frame string) (*Command, error) {
+	data, err := hex.DecodeString(removeSeparators(frame))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, errors.New("Empty command")
+	}
+
+	cmd := &Command{
+		initiator:   uint32((data[0] >> 4) & 0xF),
+		destination: uint32(data[0] & 0xF),
+	}
+	if len(data) > 1 {
+		cmd.opcode_set = 1
+		cmd.opcode = int(data[1])
+		cmd.Operation = opcodes[cmd.opcode]
+	}
+	if len(data) > 2 {
+		cmd.parameters = data[2:]
+	}
+
+	return cmd, nil
+}
+
+// ActiveSourceRoute - get the HDMI ports on the way from this adapter to
+// the active source, e.g. [2, 1] for 2.1.0.0 if the adapter is at 0.0.0.0:
+// the source is connected to port 1 of the device on our port 2. Seen from
+// an adapter at 2.0.0.0 the route to 2.1.0.0 is [1]. The route is empty if
+// the active source is at our own address, an error is returned if it isn't
+// connected behind this adapter
+func (c *Connection) ActiveSourceRoute() ([]int, error) {
+	own, err := c.MyPhysicalAddress()
+	if err != nil {
+		return nil, err
+	}
+	active, err := c.ActiveSource()
+	if err != nil {
+		return nil, err
+	}
+	addr, err := PhysicalAddressFromString(c.GetDevicePhysicalAddress(active))
+	if err != nil {
+		return nil, err
+	}
+	if addr == 0xFFFF {
+		return nil, errors.New("Unknown physical address of the active source")
+	}
+	return relativePortPath(own, addr)
+}
+
+// relativePortPath - the HDMI ports on the way from the device at the
+// physical address from to the one at to
+func relativePortPath(from, to uint16) ([]int, error) {
+	own, target := portPath(from), portPath(to)
+	if len(target) < len(own) {
+		return nil, fmt.Errorf("%s isn't connected behind %s", ParsePhysicalAddress(to), ParsePhysicalAddress(from))
+	}
+	for i, port := range own {
+		if target[i] != port {
+			return nil, fmt.Errorf("%s isn't connected behind %s", ParsePhysicalAddress(to), ParsePhysicalAddress(from))
+		}
+	}
+	return target[len(own):], nil
+}
+
+// validPhysicalAddress - check that the physical address describes a
+// position in the HDMI tree: no port after a zero (e.g. 1.0.2.0), and not
+// the invalid address f.f.f.f
+func validPhysicalAddress(addr uint16) error {
+	if addr == 0xFFFF || len(portPath(addr)) != len(strings.TrimRight(fmt.Sprintf("%04x", addr), "0")) {
+		return fmt.Errorf("Invalid physical address %s", ParsePhysicalAddress(addr))
+	}
+	return nil
+}
+
+// portPath - the HDMI ports in a physical address, up to the first zero
+func portPath(addr uint16) []int {
+	ports := []int{}
+	for shift := 12; shift >= 0; shift -= 4 {
+		port := int(addr>>uint(shift)) & 0xF
+		if port == 0 {
+			break
+		}
+		ports = append(ports, port)
+	}
+	return ports
+}
+
+// ParsePhysicalAddress - format a physical address in its dotted form
+// (e.g. 0x1000 -> "1.0.0.0")
+func ParsePhysicalAddress(addr uint16) string {
+	return fmt.Sprintf("%x.%x.%x.%x", (addr>>12)&0xf, (addr>>8)&0xf, (addr>>4)&0xf, addr&0xf)
+}
+
+// PhysicalAddressFromString - parse a physical address in its dotted form
+// (e.g. "1.0.0.0" -> 0x1000)
+func PhysicalAddressFromString(s string) (uint16, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) != 4 {
+		return 0, fmt.Errorf("Invalid physical address %q", s)
+	}
+
+	var addr uint16
+	for _, part := range parts {
+		n, err := strconv.ParseUint(part, 16, 4)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid physical address %q", s)
+		}
+		addr = addr<<4 | uint16(n)
+	}
+	return addr, nil
+}
+
+// removeSeparators - remove separators (":", "-", " ", "_")
+func removeSeparators(in string) string {
+	out := strings.Map(func(r rune) rune {
+		if strings.IndexRune(":-_ ", r) < 0 {
+			return r
+		}
+		return -1
+	}, in)
+
+	return (out)
+}
+
+// GetKeyCodeByName - get the keycode by its name
+func GetKeyCodeByName(name string) int {
+	name = removeSeparators(name)
+	name = strings.ToLower(name)
+
+	// map iteration order is random, prefer the lowest code if a name is
+	// ever used twice
+	found := -1
+	for code, value := range keyList {
+		if strings.ToLower(value) == name && (found < 0 || code < found) {
+			found = code
+		}
+	}
+
+	return found
+}
+
+// NormalizeKeyName - resolve a key name like GetKeyCodeByName does
+// (ignoring case and separators) and return its canonical name and code,
+// e.g. "VOLUME_UP" -> "VolumeUp", 0x41
+func NormalizeKeyName(name string) (canonical string, code int, ok bool) {
+	code = GetKeyCodeByName(name)
+	if code < 0 {
+		return "", -1, false
+	}
+	return keyList[code], code, true
+}
+
+// OpcodesInGroup - get the opcodes of a feature group in ascending order,
+// e.g. to subscribe to all audio related commands
+func OpcodesInGroup(group OpcodeGroup) []int {
+	var result []int
+	for opcode, g := range opcodeGroups {
+		if g == group {
+			result = append(result, opcode)
+		}
+	}
+	sort.Ints(result)
+	return result
+}
+
+// OpcodeName - get the name of an opcode (e.g. 0x82 -> "ACTIVE_SOURCE").
+// 0xFD (NONE) only marks a message without an opcode, so it isn't reported
+func OpcodeName(opcode int) (string, bool) {
+	if opcode == 0xFD {
+		return "", false
+	}
+	name, ok := opcodes[opcode]
+	return name, ok
+}
+
+// OpcodeByName - get an opcode by its name, separators and case are
+// ignored (e.g. "ACTIVE_SOURCE" or "active source" -> 0x82)
+func OpcodeByName(name string) (int, bool) {
+	name = strings.ToLower(removeSeparators(name))
+
+	for opcode, value := range opcodes {
+		if opcode != 0xFD && strings.ToLower(removeSeparators(value)) == name {
+			return opcode, true
+		}
+	}
+
+	return -1, false
+}
+
+// KeyNames - get the sorted names of all known keys
+func KeyNames() []string {
+	names := make([]string, 0, len(keyList))
+
+	for _, name := range keyList {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// KeyCodes - get all known key codes and their names
+func KeyCodes() map[int]string {
+	codes := make(map[int]string, len(keyList))
+
+	for code, name := range keyList {
+		codes[code] = name
+	}
+	return codes
+}
+
+// GetLogicalAddressByName - get logical address by its name, the first
+// device of a type can be named with or without a trailing 1 (e.g. "Tuner"
+// or "Tuner1"), returns -1 for unknown names
+func GetLogicalAddressByName(name string) int {
+	name = strings.ToLower(removeSeparators(name))
+	if name == "" {
+		return -1
+	}
+
+	for i, logicalName := range logicalNames {
+		logicalName = strings.ToLower(logicalName)
+		if logicalName == name || logicalName+"1" == name {
+			return i
+		}
+	}
+
+	if name == "unregistered" {
+		return AddrUnregistered
+	}
+
+	return -1
+}
+
+// GetLogicalNameByAddress - get logical name by address, returns "Unknown"
+// for invalid addresses
 func GetLogicalNameByAddress(addr int) string {
-	return logicalNames[addr]
+	name, ok := LogicalNameByAddress(addr)
+	if !ok {
+		return "Unknown"
+	}
+	return name
+}
+
+// LogicalNameByAddress - get logical name by address, ok is false for
+// invalid addresses
+func LogicalNameByAddress(addr int) (string, bool) {
+	if addr < 0 || addr >= len(logicalNames) {
+		return "", false
+	}
+	return logicalNames[addr], true
+}
+
+// DeviceTypeForAddress - get the device type that the logical address is
+// reserved for (e.g. 8 -> DeviceTypePlayback). The reserved, specific use
+// and unregistered addresses and invalid addresses are DeviceTypeReserved
+func DeviceTypeForAddress(addr int) DeviceType {
+	if addr < 0 || addr >= len(addressDeviceTypes) {
+		return DeviceTypeReserved
+	}
+	return addressDeviceTypes[addr]
+}
+
+// AddressesForDeviceType - get the logical addresses that are reserved for
+// the device type in ascending order (e.g. DeviceTypePlayback -> 4, 8, 11)
+func AddressesForDeviceType(t DeviceType) []int {
+	var addresses []int
+	for addr, addrType := range addressDeviceTypes {
+		if addrType == t {
+			addresses = append(addresses, addr)
+		}
+	}
+	return addresses
 }
 
-// GetVendorByID - Get vendor by ID
+// GetVendorByID - Get vendor by ID, unknown IDs are returned in hex (e.g.
+// "0x123456")
 func GetVendorByID(id uint64) string {
-	return vendorList[id]
+	vendorMu.RLock()
+	defer vendorMu.RUnlock()
+
+	if name, ok := vendorList[id]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%06x", id)
+}
+
+// RegisterVendor - add a vendor to the table used by GetVendorByID
+func RegisterVendor(id uint64, name string) {
+	vendorMu.Lock()
+	defer vendorMu.Unlock()
+
+	vendorList[id] = name
+}
+
+// truncate - cut s to at most n bytes without splitting a UTF-8 character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
 }
@@ -0,0 +1,516 @@
+package cec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseCommand(t *testing.T) {
+	cmd, err := ParseCommand("04:36")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd.Initiator() != 0 || cmd.Destination() != 4 {
+		t.Errorf("unexpected addresses %d -> %d", cmd.Initiator(), cmd.Destination())
+	}
+	if cmd.Opcode() != 0x36 || cmd.Operation != "STANDBY" {
+		t.Errorf("unexpected opcode %x (%s)", cmd.Opcode(), cmd.Operation)
+	}
+
+	cmd, err = ParseCommand("4f 82 10 00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cmd.Parameters(), []byte{0x10, 0x00}) {
+		t.Errorf("unexpected parameters %x", cmd.Parameters())
+	}
+
+	for _, frame := range []string{"", "0", "04:3", "zz:36"} {
+		if _, err := ParseCommand(frame); err == nil {
+			t.Errorf("expected an error for %q", frame)
+		}
+	}
+}
+
+func TestPhysicalAddress(t *testing.T) {
+	if s := ParsePhysicalAddress(0x1000); s != "1.0.0.0" {
+		t.Errorf("expected 1.0.0.0, got %s", s)
+	}
+	if s := ParsePhysicalAddress(0x21af); s != "2.1.a.f" {
+		t.Errorf("expected 2.1.a.f, got %s", s)
+	}
+
+	addr, err := PhysicalAddressFromString("2.1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr != 0x2100 {
+		t.Errorf("expected 0x2100, got 0x%x", addr)
+	}
+
+	for _, s := range []string{"", "1.0.0", "1.0.0.0.0", "10.0.0.0", "g.0.0.0"} {
+		if _, err := PhysicalAddressFromString(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
+
+func TestOpcodeName(t *testing.T) {
+	if name, ok := OpcodeName(0x82); !ok || name != "ACTIVE_SOURCE" {
+		t.Errorf("unexpected name %q for 0x82", name)
+	}
+	if _, ok := OpcodeName(0xFD); ok {
+		t.Error("0xFD shouldn't resolve to an opcode name")
+	}
+
+	for _, name := range []string{"ACTIVE_SOURCE", "active source", "ActiveSource"} {
+		if opcode, ok := OpcodeByName(name); !ok || opcode != 0x82 {
+			t.Errorf("unexpected opcode 0x%x for %q", opcode, name)
+		}
+	}
+	if _, ok := OpcodeByName("NONE"); ok {
+		t.Error("NONE shouldn't resolve to an opcode")
+	}
+}
+
+func TestFeatureAbort(t *testing.T) {
+	cmd, _ := ParseCommand("04:00:8f:04")
+	opcode, reason, ok := cmd.FeatureAbort()
+	if !ok || opcode != 0x8F || reason != "refused" {
+		t.Errorf("unexpected feature abort 0x%x %q %v", opcode, reason, ok)
+	}
+
+	cmd, _ = ParseCommand("04:36")
+	if _, _, ok := cmd.FeatureAbort(); ok {
+		t.Error("STANDBY isn't a feature abort")
+	}
+}
+
+func TestRegisterVendor(t *testing.T) {
+	vendorMu.Lock()
+	saved := make(map[uint64]string, len(vendorList))
+	for id, name := range vendorList {
+		saved[id] = name
+	}
+	vendorMu.Unlock()
+	t.Cleanup(func() {
+		vendorMu.Lock()
+		vendorList = saved
+		vendorMu.Unlock()
+	})
+
+	if name := GetVendorByID(0x123456); name != "0x123456" {
+		t.Errorf("expected the raw ID for an unknown vendor, got %q", name)
+	}
+	RegisterVendor(0x123456, "Acme")
+	if name := GetVendorByID(0x123456); name != "Acme" {
+		t.Errorf("expected Acme, got %q", name)
+	}
+}
+
+func TestGetLogicalAddressByName(t *testing.T) {
+	tests := map[string]int{
+		"":             -1,
+		"--":           -1,
+		"TV":           0,
+		"Tuner":        3,
+		"Tuner1":       3,
+		"tuner 2":      6,
+		"Playback2":    8,
+		"Playback3":    11,
+		"Unregistered": 15,
+		"Toaster":      -1,
+	}
+	for name, expected := range tests {
+		if addr := GetLogicalAddressByName(name); addr != expected {
+			t.Errorf("%q: expected %d, got %d", name, expected, addr)
+		}
+	}
+}
+
+func TestCommandString(t *testing.T) {
+	cmd, _ := ParseCommand("04:36")
+	if s := cmd.String(); s != "04:36 STANDBY (TV→Playback)" {
+		t.Errorf("unexpected string %q", s)
+	}
+}
+
+func TestLogicalNameByAddress(t *testing.T) {
+	if name := GetLogicalNameByAddress(5); name != "Audio" {
+		t.Errorf("expected Audio, got %q", name)
+	}
+	for _, addr := range []int{-1, 16} {
+		if _, ok := LogicalNameByAddress(addr); ok {
+			t.Errorf("expected %d to be invalid", addr)
+		}
+		if name := GetLogicalNameByAddress(addr); name != "Unknown" {
+			t.Errorf("expected Unknown for %d, got %q", addr, name)
+		}
+	}
+}
+
+func TestResolveKey(t *testing.T) {
+	tests := map[string]struct {
+		code int
+		err  error
+	}{
+		"0x44":   {0x44, nil},
+		"Select": {0x00, nil},
+		"":       {-1, ErrUnknownKey},
+		"U":      {-1, ErrUnknownKey},
+		"0x":     {-1, ErrUnknownKey},
+		"0xZZ":   {-1, ErrUnknownKey},
+	}
+	for key, expected := range tests {
+		if code, err := resolveKey(key); code != expected.code || err != expected.err {
+			t.Errorf("%q: expected %d, %v, got %d, %v", key, expected.code, expected.err, code, err)
+		}
+	}
+}
+
+func TestRoutingEvent(t *testing.T) {
+	change, _ := ParseCommand("0f:80:10:00:20:00")
+	if ev, ok := routingEvent(change); !ok || ev.From != 0x1000 || ev.To != 0x2000 {
+		t.Errorf("unexpected routing event %+v, %v", ev, ok)
+	}
+	info, _ := ParseCommand("5f:81:21:00")
+	if ev, ok := routingEvent(info); !ok || ev.From != 0xFFFF || ev.To != 0x2100 {
+		t.Errorf("unexpected routing event %+v, %v", ev, ok)
+	}
+	short, _ := ParseCommand("0f:80:10:00")
+	if _, ok := routingEvent(short); ok {
+		t.Error("expected a truncated ROUTING_CHANGE to be ignored")
+	}
+}
+
+func TestAbortEvent(t *testing.T) {
+	featureAbort, _ := ParseCommand("04:00:8f:04")
+	if ev, ok := abortEvent(featureAbort); !ok || ev.From != 0 || ev.To != 4 || ev.Opcode != 0x8F || ev.Reason != "refused" {
+		t.Errorf("unexpected abort event %+v, %v", ev, ok)
+	}
+	abort, _ := ParseCommand("40:ff")
+	if ev, ok := abortEvent(abort); !ok || ev.From != 4 || ev.Opcode != -1 {
+		t.Errorf("unexpected abort event %+v, %v", ev, ok)
+	}
+	standby, _ := ParseCommand("0f:36")
+	if _, ok := abortEvent(standby); ok {
+		t.Error("expected STANDBY to be ignored")
+	}
+}
+
+func TestCECVersion(t *testing.T) {
+	if !CECVersion14.AtLeast(CECVersion13a) || CECVersion13.AtLeast(CECVersion14) {
+		t.Error("unexpected version ordering")
+	}
+	if s := CECVersion14.String(); s != "1.4" {
+		t.Errorf("expected 1.4, got %q", s)
+	}
+	if min := minCECVersions[0xC0]; min != CECVersion14 {
+		t.Errorf("expected INITIATE_ARC to require 1.4, got %v", min)
+	}
+}
+
+func TestTimerProgramOperands(t *testing.T) {
+	timer := TimerProgram{Day: 24, Month: time.December, Hour: 20, Minute: 15,
+		Duration: 90 * time.Minute, Repeat: []time.Weekday{time.Monday, time.Friday},
+		Service: [7]byte{1, 2, 3, 4, 5, 6, 7}}
+	operands, err := timer.operands()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{24, 12, 0x20, 0x15, 0x01, 0x30, 0x22, 1, 2, 3, 4, 5, 6, 7}
+	if !bytes.Equal(operands, expected) {
+		t.Errorf("expected % x, got % x", expected, operands)
+	}
+
+	timer.Month = 0
+	if _, err := timer.operands(); err == nil {
+		t.Error("expected an error for an invalid month")
+	}
+}
+
+func TestNormalizeKeyName(t *testing.T) {
+	for _, name := range []string{"Volume Up", "volumeup", "VOLUME_UP"} {
+		canonical, code, ok := NormalizeKeyName(name)
+		if !ok || canonical != "VolumeUp" || code != 0x41 {
+			t.Errorf("%q: got %q, 0x%02x, %v", name, canonical, code, ok)
+		}
+	}
+	if _, code, ok := NormalizeKeyName("Louder"); ok || code != -1 {
+		t.Errorf("expected Louder to be unknown")
+	}
+}
+
+func TestVendorID(t *testing.T) {
+	cmd, _ := ParseCommand("40:a0:00:e0:91:01:02")
+	if id, ok := cmd.VendorID(); !ok || id != 0x00E091 {
+		t.Errorf("unexpected vendor ID 0x%06x, %v", id, ok)
+	}
+	cmd, _ = ParseCommand("4f:87:00:00:f0")
+	if id, ok := cmd.VendorID(); !ok || id != 0x0000F0 {
+		t.Errorf("unexpected vendor ID 0x%06x, %v", id, ok)
+	}
+	cmd, _ = ParseCommand("40:89:01:02:03")
+	if _, ok := cmd.VendorID(); ok {
+		t.Error("expected no vendor ID for VENDOR_COMMAND")
+	}
+}
+
+func TestCommandBytes(t *testing.T) {
+	cmd := NewCommand(4, 0, 0x90, []byte{0x01})
+	if b := cmd.Bytes(); !bytes.Equal(b, []byte{0x40, 0x90, 0x01}) {
+		t.Errorf("unexpected frame % x", b)
+	}
+	poll, _ := ParseCommand("44")
+	if b := poll.Bytes(); !bytes.Equal(b, []byte{0x44}) {
+		t.Errorf("unexpected poll frame % x", b)
+	}
+}
+
+func TestMuteKeyCode(t *testing.T) {
+	// the lookup iterates a map, repeat it to catch random results
+	for i := 0; i < 100; i++ {
+		if code := GetKeyCodeByName("Mute"); code != 0x43 {
+			t.Fatalf("expected Mute to be 0x43, got 0x%02x", code)
+		}
+		if code := GetKeyCodeByName("MuteFunction"); code != 0x65 {
+			t.Fatalf("expected MuteFunction to be 0x65, got 0x%02x", code)
+		}
+	}
+}
+
+func TestOpenStepsDestroy(t *testing.T) {
+	fail := errors.New("step failed")
+	// the number of destroy calls when the step with the index fails
+	tests := map[int]int{-1: 0, 0: 0, 1: 1, 2: 1}
+	for failing, expected := range tests {
+		destroyed := 0
+		steps := make([]func() error, 3)
+		for i := range steps {
+			i := i
+			steps[i] = func() error {
+				if i == failing {
+					return fail
+				}
+				return nil
+			}
+		}
+
+		err := openSteps(func() { destroyed++ }, steps...)
+		if (failing >= 0) != (err == fail) {
+			t.Errorf("step %d failing: unexpected error %v", failing, err)
+		}
+		if destroyed != expected {
+			t.Errorf("step %d failing: expected %d destroy calls, got %d", failing, expected, destroyed)
+		}
+	}
+}
+
+func TestDecodePowerStatus(t *testing.T) {
+	tests := map[byte]PowerStatus{0x00: PowerOn, 0x01: PowerStandby,
+		0x02: PowerTransitionToOn, 0x03: PowerTransitionToStandby, 0x4F: PowerUnknown}
+	for b, expected := range tests {
+		if status := decodePowerStatus(b); status != expected {
+			t.Errorf("0x%02x: expected %v, got %v", b, expected, status)
+		}
+	}
+}
+
+func TestAddressesForDeviceType(t *testing.T) {
+	addresses := AddressesForDeviceType(DeviceTypePlayback)
+	if len(addresses) != 3 || addresses[0] != 4 || addresses[1] != 8 || addresses[2] != 11 {
+		t.Errorf("unexpected playback addresses %v", addresses)
+	}
+	for addr := 0; addr < 16; addr++ {
+		found := false
+		for _, a := range AddressesForDeviceType(DeviceTypeForAddress(addr)) {
+			found = found || a == addr
+		}
+		if !found {
+			t.Errorf("address %d missing from its device type", addr)
+		}
+	}
+	if typ := DeviceTypeForAddress(16); typ != DeviceTypeReserved {
+		t.Errorf("expected an invalid address to be reserved, got %v", typ)
+	}
+}
+
+func TestPortPath(t *testing.T) {
+	tests := map[uint16][]int{0x0000: {}, 0x2100: {2, 1}, 0x1234: {1, 2, 3, 4}}
+	for addr, expected := range tests {
+		ports := portPath(addr)
+		if fmt.Sprint(ports) != fmt.Sprint(expected) {
+			t.Errorf("%04x: expected %v, got %v", addr, expected, ports)
+		}
+	}
+}
+
+func TestRelativePortPath(t *testing.T) {
+	tests := []struct {
+		from, to uint16
+		expected []int
+	}{
+		{0x0000, 0x2100, []int{2, 1}},
+		{0x2000, 0x2100, []int{1}},
+		{0x2000, 0x2130, []int{1, 3}},
+		{0x2100, 0x2100, []int{}},
+	}
+	for _, test := range tests {
+		ports, err := relativePortPath(test.from, test.to)
+		if err != nil || fmt.Sprint(ports) != fmt.Sprint(test.expected) {
+			t.Errorf("%04x -> %04x: expected %v, got %v, %v", test.from, test.to, test.expected, ports, err)
+		}
+	}
+	for _, to := range []uint16{0x1100, 0x0000, 0x3000} {
+		if _, err := relativePortPath(0x2000, to); err == nil {
+			t.Errorf("2000 -> %04x: expected an error", to)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s        string
+		n        int
+		expected string
+	}{
+		{"Living Room", 14, "Living Room"},
+		{"Living Room Player", 14, "Living Room Pl"},
+		{"Wohnzimmer Blüte", 14, "Wohnzimmer Bl"},
+		{"Wohnzimmer Blü", 12, "Wohnzimmer B"},
+		{"日本語テレビ", 14, "日本語テ"},
+	}
+	for _, test := range tests {
+		if truncated := truncate(test.s, test.n); truncated != test.expected {
+			t.Errorf("%q to %d bytes: expected %q, got %q", test.s, test.n, test.expected, truncated)
+		}
+	}
+}
+
+func TestValidPhysicalAddress(t *testing.T) {
+	tests := map[uint16]bool{0x0000: true, 0x3000: true, 0x1230: true,
+		0x1020: false, 0x0100: false, 0xFFFF: false}
+	for addr, valid := range tests {
+		if err := validPhysicalAddress(addr); (err == nil) != valid {
+			t.Errorf("%04x: expected valid=%v, got %v", addr, valid, err)
+		}
+	}
+}
+
+func TestOpcodeGroups(t *testing.T) {
+	for opcode, name := range opcodes {
+		if _, ok := opcodeGroups[opcode]; !ok && opcode != 0xFD {
+			t.Errorf("%s (0x%02x) has no group", name, opcode)
+		}
+	}
+	for opcode := range opcodeGroups {
+		if _, ok := opcodes[opcode]; !ok {
+			t.Errorf("unknown opcode 0x%02x in a group", opcode)
+		}
+	}
+
+	power := OpcodesInGroup(GroupPower)
+	if fmt.Sprint(power) != fmt.Sprint([]int{0x36, 0x8F, 0x90}) {
+		t.Errorf("unexpected power opcodes %v", power)
+	}
+}
+
+func TestConcurrentTransmit(t *testing.T) {
+	c := NewTestConnection()
+	defer c.Close()
+
+	var active, overlaps int32
+	c.send = func(cmd *Command) error {
+		if atomic.AddInt32(&active, 1) > 1 {
+			atomic.AddInt32(&overlaps, 1)
+		}
+		runtime.Gosched()
+		atomic.AddInt32(&active, -1)
+		return nil
+	}
+
+	const goroutines, transmits = 20, 50
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < transmits; j++ {
+				if err := c.Transmit(i%15, 0, 0x44, []byte{byte(j)}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if overlaps > 0 {
+		t.Errorf("%d transmits overlapped", overlaps)
+	}
+	if sent := c.Stats().CommandsTransmitted; sent != goroutines*transmits {
+		t.Errorf("expected %d transmitted commands, got %d", goroutines*transmits, sent)
+	}
+}
+
+func TestRecordStatus(t *testing.T) {
+	cmd, _ := ParseCommand("10:0a:12")
+	status, ok := cmd.RecordStatus()
+	if !ok || status.Recording() || status.String() != "already recording" {
+		t.Errorf("unexpected record status %v, %v", status, ok)
+	}
+	if src := RecordExternalPhysicalAddress(0x2100); !bytes.Equal(src.params, []byte{0x05, 0x21, 0x00}) {
+		t.Errorf("unexpected record source % x", src.params)
+	}
+}
+
+func TestLogicalAddressConstants(t *testing.T) {
+	for addr, name := range map[int]string{AddrTV: "TV", AddrAudioSystem: "Audio", AddrPlayback3: "Playback3", AddrBroadcast: "Broadcast"} {
+		if logicalNames[addr] != name {
+			t.Errorf("address %d is %s, expected %s", addr, logicalNames[addr], name)
+		}
+	}
+}
+
+func TestTransmitHexLimits(t *testing.T) {
+	c := NewTestConnection()
+	defer c.Close()
+
+	var sent int
+	c.send = func(cmd *Command) error {
+		sent++
+		return nil
+	}
+
+	if err := c.TransmitHex("40:04"); err != nil {
+		t.Error(err)
+	}
+	for _, params := range []int{15, 65} {
+		frame := "40:47" + strings.Repeat(":41", params)
+		if err := c.TransmitHex(frame); err == nil {
+			t.Errorf("expected an error for %d parameters", params)
+		}
+	}
+	if sent != 1 {
+		t.Errorf("expected 1 transmitted command, got %d", sent)
+	}
+}
+
+func TestClosedTransmit(t *testing.T) {
+	c := NewTestConnection()
+	c.Close()
+
+	if err := c.RecordOff(AddrRecording1); err != ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+	if err := c.PowerOn(AddrTV); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
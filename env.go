@@ -0,0 +1,75 @@
+package cec
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Warning - a problem with the environment that can stop libcec from
+// working, with a hint on how to fix it
+type Warning struct {
+	Message string
+	Hint    string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%s (%s)", w.Message, w.Hint)
+}
+
+// CheckEnvironment - look for common setups in which opening the adapter
+// succeeds but nothing is sent on the bus, such as a kernel CEC driver
+// competing with libcec. Only /sys and /dev are inspected, pass the result
+// of ListAdapters to also check the adapters libcec detected. Returns nil if
+// no problems were found
+func CheckEnvironment(adapters ...AdapterInfo) []Warning {
+	return checkEnvironment("/", adapters)
+}
+
+// checkEnvironment - CheckEnvironment with /sys and /dev below root
+func checkEnvironment(root string, adapters []AdapterInfo) []Warning {
+	var warnings []Warning
+
+	// the Raspberry Pi firmware adapter can't use the bus while a kernel
+	// driver owns it, libcec has to use the kernel's device instead
+	var firmware bool
+	kernel := make(map[string]bool)
+	for _, adapter := range adapters {
+		if strings.EqualFold(adapter.Comm, "RPI") {
+			firmware = true
+		}
+		kernel[adapter.Path] = true
+	}
+
+	devices, _ := filepath.Glob(filepath.Join(root, "sys/class/cec/cec*"))
+	for _, device := range devices {
+		name := filepath.Base(device)
+		dev := filepath.Join(root, "dev", name)
+		if _, err := os.Stat(dev); err != nil {
+			continue
+		}
+		used := kernel["/dev/"+name]
+
+		// libcec may not detect a device it can't open
+		if (used || len(adapters) == 0) && !canAccess(dev) {
+			warnings = append(warnings, Warning{
+				Message: fmt.Sprintf("No permission to use /dev/%s", name),
+				Hint:    "add the user to the group owning the device, usually video",
+			})
+		}
+
+		if firmware && !used {
+			driver := "unknown"
+			if link, err := os.Readlink(filepath.Join(device, "device/driver")); err == nil {
+				driver = filepath.Base(link)
+			}
+			warnings = append(warnings, Warning{
+				Message: fmt.Sprintf("Kernel driver %s controls the CEC bus through /dev/%s", driver, name),
+				Hint:    "use a libcec built for the Linux CEC framework, the Raspberry Pi firmware adapter can't transmit while the kernel driver is loaded",
+			})
+		}
+	}
+
+	return warnings
+}
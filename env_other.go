@@ -0,0 +1,7 @@
+//go:build !unix
+
+package cec
+
+func canAccess(path string) bool {
+	return true
+}
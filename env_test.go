@@ -0,0 +1,40 @@
+package cec
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckEnvironment(t *testing.T) {
+	root := t.TempDir()
+	firmware := []AdapterInfo{{Path: "RPI", Comm: "RPI"}}
+	if warnings := checkEnvironment(root, firmware); len(warnings) != 0 {
+		t.Errorf("unexpected warnings %v", warnings)
+	}
+
+	if err := os.MkdirAll(filepath.Join(root, "sys/class/cec/cec0/device"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "dev"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../../bus/platform/drivers/vc4_hdmi", filepath.Join(root, "sys/class/cec/cec0/device/driver")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "dev/cec0"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	warnings := checkEnvironment(root, firmware)
+	if len(warnings) != 1 || !strings.Contains(warnings[0].Message, "vc4_hdmi") {
+		t.Errorf("unexpected warnings %v", warnings)
+	}
+
+	// libcec uses the kernel's device, there's no conflict
+	linux := append(firmware, AdapterInfo{Path: "/dev/cec0", Comm: "Linux"})
+	if warnings := checkEnvironment(root, linux); len(warnings) != 0 {
+		t.Errorf("unexpected warnings %v", warnings)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build unix
+
+package cec
+
+import "syscall"
+
+// canAccess - check if the device can be opened for reading and writing,
+// without opening it
+func canAccess(path string) bool {
+	// R_OK | W_OK
+	return syscall.Access(path, 0x6) == nil
+}
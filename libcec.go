@@ -14,6 +14,7 @@ ICECCallbacks g_callbacks;
 void logMessageCallback(void *, const cec_log_message *);
 void commandReceived(void *, const cec_command *);
 void keyPressed(void *, const cec_keypress *);
+void alertCallback(void *, const libcec_alert, const libcec_parameter);
 
 libcec_configuration * allocConfiguration()  {
 	libcec_configuration * ret = (libcec_configuration*)malloc(sizeof(libcec_configuration));
@@ -31,7 +32,7 @@ void setupCallbacks(libcec_configuration *conf)
 	g_callbacks.keyPress = &keyPressed;
 	g_callbacks.commandReceived = &commandReceived;
 	g_callbacks.configurationChanged = NULL;
-	g_callbacks.alert = NULL;
+	g_callbacks.alert = &alertCallback;
 	g_callbacks.menuStateChanged = NULL;
 	g_callbacks.sourceActivated = NULL;
 	(*conf).callbacks = &g_callbacks;
@@ -39,27 +40,73 @@ void setupCallbacks(libcec_configuration *conf)
 
 void setName(libcec_configuration *conf, char *name)
 {
-	snprintf((*conf).strDeviceName, 13, "%s", name);
+	snprintf((*conf).strDeviceName, sizeof((*conf).strDeviceName), "%s", name);
 }
 
 */
 import "C"
 
 import (
-	"encoding/hex"
+	"context"
 	"errors"
 	"fmt"
-	"log"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 	"unsafe"
 )
 
-// Connection class
+// Connection class. A connection is safe for concurrent use by multiple
+// goroutines, all calls into libcec are serialized
 type Connection struct {
-	connection C.libcec_connection_t
-	Commands   chan *Command
-	KeyPresses chan int
-	Messages   chan string
+	connection    C.libcec_connection_t
+	Commands      chan *Command
+	KeyPresses    chan int
+	KeyEvents     chan KeyEvent
+	Messages      chan string
+	LogMessages   chan LogMessage
+	PowerEvents   chan PowerEvent
+	RoutingEvents chan RoutingEvent
+	AbortEvents   chan AbortEvent
+	Reconnects    chan ReconnectEvent
+	HealthEvents  chan HealthEvent
+	Alerts        chan Alert
+
+	mu                  sync.Mutex
+	libMu               sync.Mutex
+	send                func(cmd *Command) error
+	onCommand           func(*Command)
+	onKeyPress          func(KeyEvent)
+	onLogMessage        func(LogMessage)
+	responder           Responder
+	handlers            chan func()
+	closed              bool
+	logger              Logger
+	logLevel            int
+	subscriptions       []*subscription
+	droppedCommands     atomic.Uint64
+	commandsReceived    atomic.Uint64
+	commandsTransmitted atomic.Uint64
+	transmitFailures    atomic.Uint64
+	reconnections       atomic.Uint64
+	cacheTTL            time.Duration
+	deviceCache         map[int]cachedDevice
+	osdNames            map[int]string
+	adapterName         string
+	options             OpenOptions
+	autoReconnect       bool
+	initiator           int
+	initiatorSet        bool
+	keyDelay            time.Duration
+	keyDelaySet         bool
+	reconnecting        bool
+	keepAliveStop       chan struct{}
+	draining            bool
+	inflight            sync.WaitGroup
+	test                bool
+	testAddress         int
+	testPhysicalAddress uint16
 }
 
 type cecAdapter struct {
@@ -67,17 +114,25 @@ type cecAdapter struct {
 	Comm string
 }
 
-func cecInit(c *Connection, deviceName string) (C.libcec_connection_t, error) {
+func cecInit(c *Connection, opts OpenOptions) (C.libcec_connection_t, error) {
 	var connection C.libcec_connection_t
 	var conf *C.libcec_configuration = C.allocConfiguration()
 	defer C.freeConfiguration(conf)
 
 	conf.clientVersion = C.uint32_t(C.LIBCEC_VERSION_CURRENT)
 
-	conf.deviceTypes.types[0] = C.CEC_DEVICE_TYPE_RECORDING_DEVICE
+	deviceTypes := opts.DeviceTypes
+	if len(deviceTypes) == 0 {
+		deviceTypes = []DeviceType{DeviceTypeRecording}
+	}
+	setDeviceTypes(conf, deviceTypes)
+	if opts.HDMIPort > 0 {
+		conf.iHDMIPort = C.uint8_t(opts.HDMIPort)
+		conf.baseDevice = C.cec_logical_address(opts.BaseDevice)
+	}
 	conf.callbackParam = unsafe.Pointer(c)
 
-	C.setName(conf, C.CString(deviceName))
+	C.setName(conf, C.CString(opts.DeviceName))
 	C.setupCallbacks(conf)
 
 	connection = C.libcec_initialise(conf)
@@ -87,29 +142,122 @@ func cecInit(c *Connection, deviceName string) (C.libcec_connection_t, error) {
 	return connection, nil
 }
 
+// setDeviceTypes - set the device types of the configuration, unused slots
+// are marked as reserved
+func setDeviceTypes(conf *C.libcec_configuration, types []DeviceType) {
+	for i := range conf.deviceTypes.types {
+		conf.deviceTypes.types[i] = C.CEC_DEVICE_TYPE_RESERVED
+		if i < len(types) {
+			conf.deviceTypes.types[i] = C.cec_device_type(types[i])
+		}
+	}
+}
+
 func getAdapter(connection C.libcec_connection_t, name string) (cecAdapter, error) {
 	var adapter cecAdapter
 
 	var deviceList [10]C.cec_adapter
 	devicesFound := int(C.libcec_find_adapters(connection, &deviceList[0], 10, nil))
 
+	var ports []string
 	for i := 0; i < devicesFound; i++ {
 		device := deviceList[i]
-		adapter.Path = C.GoStringN(&device.path[0], 1024)
-		adapter.Comm = C.GoStringN(&device.comm[0], 1024)
+		adapter.Path = C.GoString(&device.path[0])
+		adapter.Comm = C.GoString(&device.comm[0])
 
 		if strings.Contains(adapter.Path, name) || strings.Contains(adapter.Comm, name) {
 			return adapter, nil
 		}
+		ports = append(ports, adapter.Comm)
+	}
+
+	if len(ports) == 0 {
+		return adapter, fmt.Errorf("%w: no adapters detected", ErrNoAdapter)
+	}
+	return adapter, fmt.Errorf("%w matching %q, %d adapters detected on %s (see ListAdapters)",
+		ErrNoAdapter, name, len(ports), strings.Join(ports, ", "))
+}
+
+// ListAdapters - list the CEC adapters that are connected to this system
+func ListAdapters() ([]AdapterInfo, error) {
+	connection, err := cecInit(new(Connection), OpenOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer C.libcec_destroy(connection)
+
+	var deviceList [10]C.cec_adapter_descriptor
+	devicesFound := int(C.libcec_detect_adapters(connection, &deviceList[0], 10, nil, 0))
+	if devicesFound < 0 {
+		return nil, errors.New("Error in cec_detect_adapters")
+	}
+
+	adapters := make([]AdapterInfo, devicesFound)
+	for i := range adapters {
+		device := deviceList[i]
+		adapters[i].Path = C.GoString(&device.strComPath[0])
+		adapters[i].Comm = C.GoString(&device.strComName[0])
+		adapters[i].FirmwareVersion = int(device.iFirmwareVersion)
+		adapters[i].FirmwareBuildDate = buildDate(uint32(device.iFirmwareBuildDate))
+	}
+
+	return adapters, nil
+}
+
+// AdapterInfo - get the firmware of the opened adapter and the version of
+// the libcec library in use
+func (c *Connection) AdapterInfo() (AdapterInfo, error) {
+	if err := c.lockLib(); err != nil {
+		return AdapterInfo{}, err
+	}
+	defer c.libMu.Unlock()
+
+	conf := C.allocConfiguration()
+	defer C.freeConfiguration(conf)
+
+	if C.libcec_get_current_configuration(c.connection, conf) != 1 {
+		return AdapterInfo{}, errors.New("Error in cec_get_current_configuration")
 	}
+	return AdapterInfo{
+		Comm:              c.adapterName,
+		FirmwareVersion:   int(conf.iFirmwareVersion),
+		FirmwareBuildDate: buildDate(uint32(conf.iFirmwareBuildDate)),
+		LibCECVersion:     versionString(uint32(conf.serverVersion)),
+	}, nil
+}
+
+// LibCECVersion - the version of the libcec headers this package was built
+// against (e.g. "6.0.2")
+func LibCECVersion() string {
+	return versionString(uint32(C.LIBCEC_VERSION_CURRENT))
+}
 
-	return adapter, errors.New("No Device Found")
+func versionString(version uint32) string {
+	var buf [32]C.char
+	C.libcec_version_to_string(C.uint32_t(version), &buf[0], C.size_t(len(buf)))
+	return C.GoString(&buf[0])
 }
 
-func openAdapter(connection C.libcec_connection_t, adapter cecAdapter) error {
+// buildDate - convert a firmware build date (unix time, 0 if unknown)
+func buildDate(date uint32) time.Time {
+	if date == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(date), 0)
+}
+
+func openAdapter(connection C.libcec_connection_t, adapter cecAdapter, timeout time.Duration) error {
 	C.libcec_init_video_standalone(connection)
 
-	result := C.libcec_open(connection, C.CString(adapter.Comm), C.CEC_DEFAULT_CONNECT_TIMEOUT)
+	timeoutMs := C.uint32_t(C.CEC_DEFAULT_CONNECT_TIMEOUT)
+	if timeout > 0 {
+		timeoutMs = C.uint32_t(timeout / time.Millisecond)
+	}
+
+	comm := C.CString(adapter.Comm)
+	defer C.free(unsafe.Pointer(comm))
+
+	result := C.libcec_open(connection, comm, timeoutMs)
 	if result < 1 {
 		return errors.New("Failed to open adapter")
 	}
@@ -117,96 +265,716 @@ func openAdapter(connection C.libcec_connection_t, adapter cecAdapter) error {
 	return nil
 }
 
-// Transmit CEC command - command is encoded as a hex string with
-// colons (e.g. "40:04")
-func (c *Connection) Transmit(command string) {
-	var cecCommand C.cec_command
+// Transmit - send a CEC command with the given opcode and parameters from
+// the initiator to the destination logical address. An error is returned
+// when the command is invalid or wasn't acknowledged
+func (c *Connection) Transmit(initiator, destination int, opcode int, params []byte) error {
+	if initiator < 0 || initiator > 15 {
+		return fmt.Errorf("Invalid initiator address %d", initiator)
+	}
+	if destination < 0 || destination > 15 {
+		return fmt.Errorf("Invalid destination address %d", destination)
+	}
+	if opcode < 0 || opcode > 0xFF {
+		return fmt.Errorf("Invalid opcode 0x%x", opcode)
+	}
 
-	cmd, err := hex.DecodeString(removeSeparators(command))
+	cmd := NewCommand(initiator, destination, opcode, params)
+	if err := validCommand(cmd); err != nil {
+		return err
+	}
+	return c.transmit(cmd)
+}
+
+// TransmitHex - send a CEC command that is encoded as a hex string with
+// colons (e.g. "40:04"), it is checked like the commands sent by Transmit
+func (c *Connection) TransmitHex(command string) error {
+	cmd, err := ParseCommand(command)
 	if err != nil {
-		log.Fatal(err)
-	}
-	cmdLen := len(cmd)
-
-	if cmdLen > 0 {
-		cecCommand.initiator = C.cec_logical_address((cmd[0] >> 4) & 0xF)
-		cecCommand.destination = C.cec_logical_address(cmd[0] & 0xF)
-		if cmdLen > 1 {
-			cecCommand.opcode_set = 1
-			cecCommand.opcode = C.cec_opcode(cmd[1])
-		} else {
-			cecCommand.opcode_set = 0
+		return err
+	}
+	if err := validCommand(cmd); err != nil {
+		return err
+	}
+
+	return c.transmit(cmd)
+}
+
+// validCommand - check that the command fits in a CEC frame
+func validCommand(cmd *Command) error {
+	if cmd.initiator > 15 {
+		return fmt.Errorf("Invalid initiator address %d", cmd.initiator)
+	}
+	if cmd.destination > 15 {
+		return fmt.Errorf("Invalid destination address %d", cmd.destination)
+	}
+	// a CEC frame is at most 16 bytes, including the header and opcode
+	if len(cmd.parameters) > 14 {
+		return fmt.Errorf("Too many parameters (%d), at most 14 are allowed", len(cmd.parameters))
+	}
+	return nil
+}
+
+func (c *Connection) transmit(cmd *Command) error {
+	if err := c.lockLib(); err != nil {
+		return err
+	}
+	defer c.libMu.Unlock()
+
+	if c.send != nil {
+		err := c.send(cmd)
+		if err != nil {
+			c.transmitFailures.Add(1)
+			return err
+		}
+		c.commandsTransmitted.Add(1)
+		return nil
+	}
+
+	var cecCommand C.cec_command
+
+	cecCommand.initiator = C.cec_logical_address(cmd.initiator)
+	cecCommand.destination = C.cec_logical_address(cmd.destination)
+	cecCommand.opcode_set = C.int8_t(cmd.opcode_set)
+	cecCommand.opcode = C.cec_opcode(cmd.opcode)
+	cecCommand.parameters.size = C.uint8_t(len(cmd.parameters))
+	for i, p := range cmd.parameters {
+		cecCommand.parameters.data[i] = C.uint8_t(p)
+	}
+	cecCommand.transmit_timeout = C.CEC_DEFAULT_TRANSMIT_TIMEOUT
+
+	if !c.sent(C.libcec_transmit(c.connection, (*C.cec_command)(&cecCommand))) {
+		return fmt.Errorf("Error in cec_transmit: %w", ErrNotACKed)
+	}
+	return nil
+}
+
+// sent - count the result of a libcec call sending a frame in the Stats
+// counters, reports if the frame was sent
+func (c *Connection) sent(result C.int) bool {
+	if result != 1 {
+		c.transmitFailures.Add(1)
+		return false
+	}
+	c.commandsTransmitted.Add(1)
+	return true
+}
+
+// Close - close the adapter, destroy the cec connection and close its
+// event channels (Commands, KeyPresses, etc. and the channels returned by
+// SubscribeOpcodes). Closing a connection twice is a no-op, other methods
+// return ErrClosed once the connection is closed
+func (c *Connection) Close() error {
+	c.libMu.Lock()
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		c.libMu.Unlock()
+		return nil
+	}
+	c.closed = true
+	connection := c.connection
+	c.connection = nil
+	c.mu.Unlock()
+
+	// libcec waits for running callbacks while destroying the connection, so
+	// c.mu must not be held here. No callbacks are delivered afterwards,
+	// which makes it safe to close the channels
+	if connection != nil {
+		C.libcec_close(connection)
+		C.libcec_destroy(connection)
+	}
+	c.libMu.Unlock()
+
+	if c.Commands != nil {
+		close(c.Commands)
+	}
+	if c.KeyPresses != nil {
+		close(c.KeyPresses)
+	}
+	if c.KeyEvents != nil {
+		close(c.KeyEvents)
+	}
+	if c.Messages != nil {
+		close(c.Messages)
+	}
+	if c.LogMessages != nil {
+		close(c.LogMessages)
+	}
+	if c.PowerEvents != nil {
+		close(c.PowerEvents)
+	}
+	if c.RoutingEvents != nil {
+		close(c.RoutingEvents)
+	}
+	if c.AbortEvents != nil {
+		close(c.AbortEvents)
+	}
+	if c.Alerts != nil {
+		close(c.Alerts)
+	}
+
+	c.mu.Lock()
+	for _, sub := range c.subscriptions {
+		close(sub.ch)
+	}
+	c.subscriptions = nil
+	if c.Reconnects != nil {
+		close(c.Reconnects)
+	}
+	if c.keepAliveStop != nil {
+		close(c.keepAliveStop)
+		c.keepAliveStop = nil
+	}
+	if c.HealthEvents != nil {
+		close(c.HealthEvents)
+	}
+	if c.handlers != nil {
+		close(c.handlers)
+		c.handlers = nil
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+// SetKeepAlive - poll the TV at the given interval to notice a bus that
+// went quiet, a HealthEvent is sent on the HealthEvents channel whenever
+// the TV stops or starts acknowledging the polls again. An interval of zero
+// stops polling
+func (c *Connection) SetKeepAlive(interval time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.keepAliveStop != nil {
+		close(c.keepAliveStop)
+		c.keepAliveStop = nil
+	}
+	if interval <= 0 || c.closed {
+		return
+	}
+	c.keepAliveStop = make(chan struct{})
+	go c.keepAlive(interval, c.keepAliveStop)
+}
+
+func (c *Connection) keepAlive(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	healthy := true
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
 		}
-		if cmdLen > 2 {
-			cecCommand.parameters.size = C.uint8_t(cmdLen - 2)
-			for i := 0; i < cmdLen-2; i++ {
-				cecCommand.parameters.data[i] = C.uint8_t(cmd[i+2])
+
+		acked, err := c.PollDevice(int(C.CECDEVICE_TV))
+		if err != nil {
+			return
+		}
+		if acked == healthy {
+			continue
+		}
+		healthy = acked
+		if !healthy {
+			c.logf("cec keep-alive: the TV doesn't acknowledge polls")
+		}
+
+		c.mu.Lock()
+		if !c.closed && c.HealthEvents != nil {
+			select {
+			case c.HealthEvents <- HealthEvent{Healthy: healthy, Time: time.Now()}:
+			default:
 			}
-		} else {
-			cecCommand.parameters.size = 0
 		}
+		c.mu.Unlock()
 	}
+}
+
+// SetAutoReconnect - reopen the adapter in the background when libcec
+// reports that the connection to it was lost, retrying with an increasing
+// delay. A ReconnectEvent is sent on Reconnects once the adapter is back
+func (c *Connection) SetAutoReconnect(enabled bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	C.libcec_transmit(c.connection, (*C.cec_command)(&cecCommand))
+	c.autoReconnect = enabled
 }
 
-// Destroy - destroy the cec connection
-func (c *Connection) Destroy() {
+// connectionLost - called by the alert callback when libcec lost the
+// connection to the adapter
+func (c *Connection) connectionLost() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.autoReconnect || c.reconnecting || c.closed {
+		return
+	}
+	c.reconnecting = true
+
+	// the connection can't be destroyed from within a libcec callback
+	go c.reconnect(time.Now())
+}
+
+func (c *Connection) reconnect(lost time.Time) {
+	c.libMu.Lock()
+	c.mu.Lock()
+	old := c.connection
+	c.connection = nil
+	opts, name := c.options, c.adapterName
+	c.mu.Unlock()
+	c.libMu.Unlock()
+
+	// libcec waits for running callbacks while destroying the connection,
+	// and the callbacks take c.mu, so no lock may be held here
+	if old != nil {
+		C.libcec_close(old)
+		C.libcec_destroy(old)
+	}
+
+	var connection C.libcec_connection_t
+	attempts := 0
+	for backoff := time.Second; ; {
+		time.Sleep(backoff)
+		if c.isClosed() {
+			return
+		}
+		attempts++
+
+		var err error
+		connection, err = cecInit(c, opts)
+		if err == nil {
+			var adapter cecAdapter
+			adapter, err = getAdapter(connection, name)
+			if err == nil {
+				err = openAdapter(connection, adapter, opts.ConnectTimeout)
+			}
+			if err != nil {
+				C.libcec_destroy(connection)
+			}
+		}
+		if err == nil {
+			break
+		}
+
+		c.logf("cec reconnect failed: %v", err)
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+
+	c.libMu.Lock()
+	c.mu.Lock()
+	closed := c.closed
+	if !closed {
+		c.connection = connection
+		c.reconnecting = false
+		c.reconnections.Add(1)
+
+		if c.Reconnects != nil {
+			select {
+			case c.Reconnects <- ReconnectEvent{Lost: lost, Restored: time.Now(), Attempts: attempts}:
+			default:
+			}
+		}
+	}
+	c.mu.Unlock()
+	c.libMu.Unlock()
+
+	// closed while reconnecting, the new connection was never handed out
+	if closed {
+		C.libcec_close(connection)
+		C.libcec_destroy(connection)
+	}
+}
+
+// destroy - release the libcec connection of a connection that failed to
+// open, Close takes care of open connections
+func (c *Connection) destroy() {
 	C.libcec_destroy(c.connection)
+	c.connection = nil
+}
+
+// Destroy - destroy the cec connection, same as Close
+func (c *Connection) Destroy() {
+	c.Close()
+}
+
+func (c *Connection) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.closed || c.draining
+}
+
+// lockLib - take the lock that serializes the calls into libcec, which
+// isn't safe for concurrent use. c.connection may only be used while the
+// lock is held, Close and reconnect swap it under the lock. The lock isn't
+// taken if the connection is closed, ErrClosed is returned instead.
+// Callbacks never take this lock
+func (c *Connection) lockLib() error {
+	c.libMu.Lock()
+	if c.isClosed() {
+		c.libMu.Unlock()
+		return ErrClosed
+	}
+	return nil
+}
+
+// Shutdown - close the connection gracefully: new operations fail with
+// ErrClosed right away, while requests that are waiting for a reply (and
+// WaitFor calls) may complete until the context is done. The context's
+// error is returned if it is done first, the connection is closed either way
+func (c *Connection) Shutdown(ctx context.Context) error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.draining = true
+	c.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		c.inflight.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	c.Close()
+	return err
 }
 
-// PowerOn - power on the device with the given logical address
+// begin - register an operation that Shutdown waits for, it must be
+// finished by calling c.inflight.Done()
+func (c *Connection) begin() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed || c.draining {
+		return ErrClosed
+	}
+	c.inflight.Add(1)
+	return nil
+}
+
+// PowerOn - power on the device with the given logical address. A TV is
+// sent IMAGE_VIEW_ON, other devices are sent the power on function key. If
+// the device doesn't acknowledge that, the Power key is sent instead. Errors
+// wrap ErrNotACKed when a command wasn't acknowledged. The TV isn't switched
+// to our input, use BecomeActiveSource for that
 func (c *Connection) PowerOn(address int) error {
-	if C.libcec_power_on_devices(c.connection, C.cec_logical_address(address)) != 0 {
-		return errors.New("Error in cec_power_on_devices")
+	if address != AddrTV {
+		if err := c.Key(address, "PowerOn"); err != nil {
+			if c.Key(address, "Power") != nil {
+				return fmt.Errorf("Power on of device %d failed: %w", address, err)
+			}
+		}
+		return nil
+	}
+
+	if err := c.transmitFromSelf(AddrTV, 0x04, nil); err != nil {
+		if c.Key(address, "Power") != nil {
+			return fmt.Errorf("IMAGE_VIEW_ON failed: %w", err)
+		}
 	}
 	return nil
 }
 
 // Standby - put the device with the given address in standby mode
 func (c *Connection) Standby(address int) error {
-	if C.libcec_standby_devices(c.connection, C.cec_logical_address(address)) != 0 {
-		return errors.New("Error in cec_standby_devices")
+	if err := c.lockLib(); err != nil {
+		return err
+	}
+	defer c.libMu.Unlock()
+	if !c.sent(C.libcec_standby_devices(c.connection, C.cec_logical_address(address))) {
+		return fmt.Errorf("Error in cec_standby_devices: %w", ErrNotACKed)
 	}
 	return nil
 }
 
-// VolumeUp - send a volume up command to the amp if present
-func (c *Connection) VolumeUp() error {
-	if C.libcec_volume_up(c.connection, 1) != 0 {
-		return errors.New("Error in cec_volume_up")
+// StandbyAll - broadcast a standby command to all devices on the bus. A
+// broadcast isn't acknowledged by a single device, so a failure means the
+// frame couldn't be sent on the bus rather than ErrNotACKed
+func (c *Connection) StandbyAll() error {
+	if err := c.lockLib(); err != nil {
+		return err
+	}
+	defer c.libMu.Unlock()
+	if !c.sent(C.libcec_standby_devices(c.connection, C.CECDEVICE_BROADCAST)) {
+		return errors.New("Error in cec_standby_devices: broadcast not sent")
 	}
 	return nil
 }
 
-// VolumeDown - send a volume down command to the amp if present
+// VolumeUp - send a volume up key to the amp, or to the TV if no amp is
+// present
+func (c *Connection) VolumeUp() error {
+	if c.isClosed() {
+		return ErrClosed
+	}
+	return c.Key(c.audioDevice(), 0x41)
+}
+
+// VolumeDown - send a volume down key to the amp, or to the TV if no amp is
+// present
 func (c *Connection) VolumeDown() error {
-	if C.libcec_volume_down(c.connection, 1) != 0 {
-		return errors.New("Error in cec_volume_down")
+	if c.isClosed() {
+		return ErrClosed
 	}
-	return nil
+	return c.Key(c.audioDevice(), 0x42)
+}
+
+// ToggleMute - send a mute key to the amp, or to the TV if no amp is
+// present
+func (c *Connection) ToggleMute() error {
+	if c.isClosed() {
+		return ErrClosed
+	}
+	return c.Key(c.audioDevice(), 0x43)
 }
 
-// Mute - send a mute/unmute command to the amp if present
+// Mute - send a mute/unmute command to the amp if present, same as
+// ToggleMute
 func (c *Connection) Mute() error {
-	if C.libcec_mute_audio(c.connection, 1) != 0 {
-		return errors.New("Error in cec_mute_audio")
+	return c.ToggleMute()
+}
+
+// AudioStatus - get the volume (0-100) and mute state of the amp
+func (c *Connection) AudioStatus() (volume int, muted bool, err error) {
+	if err := c.lockLib(); err != nil {
+		return 0, false, err
+	}
+	defer c.libMu.Unlock()
+	if C.libcec_is_active_device(c.connection, C.CECDEVICE_AUDIOSYSTEM) != 1 {
+		return 0, false, ErrNoAudioSystem
+	}
+
+	status := C.libcec_audio_get_status(c.connection)
+	if status&C.CEC_AUDIO_VOLUME_STATUS_MASK == C.CEC_AUDIO_VOLUME_STATUS_UNKNOWN {
+		return 0, false, fmt.Errorf("Error in cec_audio_get_status: %w", ErrTimeout)
+	}
+
+	volume = int(status & C.CEC_AUDIO_VOLUME_STATUS_MASK)
+	muted = status&C.CEC_AUDIO_MUTE_STATUS_MASK != 0
+	return volume, muted, nil
+}
+
+// StartARC - ask the TV to start the audio return channel and confirm it
+// once the TV initiates it. Only an audio system can use ARC, so this
+// adapter must present itself as one (see SetDeviceTypes and SetInitiator)
+func (c *Connection) StartARC() error {
+	return c.arc(0xC3, 0xC0, 0xC1)
+}
+
+// EndARC - ask the TV to end the audio return channel and confirm it once
+// the TV terminates it
+func (c *Connection) EndARC() error {
+	return c.arc(0xC4, 0xC5, 0xC2)
+}
+
+// arc - send an ARC request to the TV, wait for the TV's initiate or
+// terminate command and answer it with the report
+func (c *Connection) arc(request, command, report int) error {
+	cmd, err := c.request(AddrTV, request, nil, command)
+	if err != nil {
+		return err
+	}
+	return c.Transmit(cmd.Destination(), AddrTV, report, nil)
+}
+
+// SetSystemAudioMode - ask the amp to turn system audio mode on or off, in
+// system audio mode the amp takes over the audio of the TV
+func (c *Connection) SetSystemAudioMode(on bool) error {
+	var params []byte
+	if on {
+		addr, err := c.MyPhysicalAddress()
+		if err != nil {
+			return err
+		}
+		params = []byte{byte(addr >> 8), byte(addr)}
+	}
+
+	reply, err := c.request(int(C.CECDEVICE_AUDIOSYSTEM), 0x70, params, 0x72)
+	if err != nil {
+		return err
+	}
+	if p := reply.Parameters(); len(p) == 0 || (p[0] == 1) != on {
+		return errors.New("System audio mode not changed")
 	}
 	return nil
 }
 
+// SystemAudioMode - check if system audio mode is on
+func (c *Connection) SystemAudioMode() (bool, error) {
+	reply, err := c.request(int(C.CECDEVICE_AUDIOSYSTEM), 0x7D, nil, 0x7E)
+	if err != nil {
+		return false, err
+	}
+	if len(reply.Parameters()) == 0 {
+		return false, errors.New("Invalid system audio mode status")
+	}
+	return reply.Parameters()[0] == 1, nil
+}
+
+// MenuLanguage - ask the device at the given address for its menu language,
+// as a 3 letter ISO 639-2 code (e.g. "eng")
+func (c *Connection) MenuLanguage(address int) (string, error) {
+	reply, err := c.request(address, 0x91, nil, 0x32)
+	if err != nil {
+		return "", err
+	}
+	if len(reply.Parameters()) != 3 {
+		return "", errors.New("Invalid menu language")
+	}
+	return string(reply.Parameters()), nil
+}
+
+// DeckControl - send a transport command (skip, stop, eject) to the
+// recording or playback device at the given address
+func (c *Connection) DeckControl(address int, mode DeckControlMode) error {
+	return c.transmitFromSelf(address, 0x42, []byte{byte(mode)})
+}
+
+// DeckStatus - ask the recording or playback device at the given address
+// for its transport state
+func (c *Connection) DeckStatus(address int) (DeckStatus, error) {
+	// status request "once"
+	reply, err := c.request(address, 0x1A, []byte{0x03}, 0x1B)
+	if err != nil {
+		return 0, err
+	}
+	if len(reply.Parameters()) == 0 {
+		return 0, errors.New("Invalid deck status")
+	}
+	return DeckStatus(reply.Parameters()[0]), nil
+}
+
+// SetDigitalTimer - program a recording timer on the recording device at
+// the given address and wait until it reports whether the timer was set
+func (c *Connection) SetDigitalTimer(address int, t TimerProgram) error {
+	params, err := t.operands()
+	if err != nil {
+		return err
+	}
+	reply, err := c.request(address, 0x97, params, 0x35)
+	if err != nil {
+		return err
+	}
+	// bit 4 of the timer status is the "programmed" indicator
+	if len(reply.Parameters()) == 0 || reply.Parameters()[0]&0x10 == 0 {
+		return errors.New("Timer not programmed")
+	}
+	return nil
+}
+
+// ClearDigitalTimer - remove a recording timer set by SetDigitalTimer from
+// the recording device at the given address, the timer is identified by
+// all of its fields
+func (c *Connection) ClearDigitalTimer(address int, t TimerProgram) error {
+	params, err := t.operands()
+	if err != nil {
+		return err
+	}
+	reply, err := c.request(address, 0x99, params, 0x43)
+	if err != nil {
+		return err
+	}
+	if len(reply.Parameters()) == 0 || reply.Parameters()[0] != 0x80 {
+		return errors.New("Timer not cleared")
+	}
+	return nil
+}
+
+// SendVendorCommand - send a VENDOR_COMMAND_WITH_ID with the given vendor
+// ID and vendor specific payload (up to 11 bytes) to the destination
+func (c *Connection) SendVendorCommand(destination int, vendorID uint64, payload []byte) error {
+	if vendorID > 0xFFFFFF {
+		return fmt.Errorf("Invalid vendor ID: 0x%x", vendorID)
+	}
+	params := append([]byte{byte(vendorID >> 16), byte(vendorID >> 8), byte(vendorID)}, payload...)
+	return c.transmitFromSelf(destination, 0xA0, params)
+}
+
+// PhysicalAddress - ask the device at the given address for its physical
+// address and wait for the report, which carries its primary device type
+// as well
+func (c *Connection) PhysicalAddress(address int) (addr uint16, deviceType DeviceType, err error) {
+	reply, err := c.request(address, 0x83, nil, 0x84)
+	if err != nil {
+		return 0, 0, err
+	}
+	p := reply.Parameters()
+	if len(p) != 3 {
+		return 0, 0, errors.New("Invalid physical address report")
+	}
+	return uint16(p[0])<<8 | uint16(p[1]), DeviceType(p[2]), nil
+}
+
+// RecordOn - ask the recording device at the given address to record the
+// given source and wait for its record status. An error is returned if the
+// device reports that it isn't recording
+func (c *Connection) RecordOn(address int, source RecordSource) error {
+	reply, err := c.request(address, 0x09, source.params, 0x0A)
+	if err != nil {
+		return err
+	}
+	status, ok := reply.RecordStatus()
+	if !ok {
+		return errors.New("Invalid record status")
+	}
+	if !status.Recording() {
+		return fmt.Errorf("Recording not started: %s", status)
+	}
+	return nil
+}
+
+// RecordOff - ask the recording device at the given address to stop
+// recording
+func (c *Connection) RecordOff(address int) error {
+	return c.transmitFromSelf(address, 0x0B, nil)
+}
+
+// audioDevice - the logical address of the audio system, or of the TV if
+// there's no audio system on the bus
+func (c *Connection) audioDevice() int {
+	if err := c.lockLib(); err != nil {
+		return C.CECDEVICE_TV
+	}
+	defer c.libMu.Unlock()
+
+	if C.libcec_is_active_device(c.connection, C.CECDEVICE_AUDIOSYSTEM) == 1 {
+		return C.CECDEVICE_AUDIOSYSTEM
+	}
+	return C.CECDEVICE_TV
+}
+
 // KeyPress - send a key press (down) command code to the given address
 func (c *Connection) KeyPress(address int, key int) error {
-	if C.libcec_send_keypress(c.connection, C.cec_logical_address(address), C.cec_user_control_code(key), 1) != 1 {
-		return errors.New("Error in cec_send_keypress")
+	if err := c.lockLib(); err != nil {
+		return err
+	}
+	defer c.libMu.Unlock()
+	if !c.sent(C.libcec_send_keypress(c.connection, C.cec_logical_address(address), C.cec_user_control_code(key), 1)) {
+		return fmt.Errorf("Error in cec_send_keypress: %w", ErrNotACKed)
 	}
 	return nil
 }
 
 // KeyRelease - send a key releas command to the given address
 func (c *Connection) KeyRelease(address int) error {
-	if C.libcec_send_key_release(c.connection, C.cec_logical_address(address), 1) != 1 {
-		return errors.New("Error in cec_send_key_release")
+	if err := c.lockLib(); err != nil {
+		return err
+	}
+	defer c.libMu.Unlock()
+	if !c.sent(C.libcec_send_key_release(c.connection, C.cec_logical_address(address), 1)) {
+		return fmt.Errorf("Error in cec_send_key_release: %w", ErrNotACKed)
 	}
 	return nil
 }
@@ -214,6 +982,11 @@ func (c *Connection) KeyRelease(address int) error {
 // GetActiveDevices - returns an array of active devices
 func (c *Connection) GetActiveDevices() [16]bool {
 	var devices [16]bool
+	if err := c.lockLib(); err != nil {
+		return devices
+	}
+	defer c.libMu.Unlock()
+
 	result := C.libcec_get_active_devices(c.connection)
 
 	for i := 0; i < 16; i++ {
@@ -227,14 +1000,375 @@ func (c *Connection) GetActiveDevices() [16]bool {
 
 // GetDeviceOSDName - get the OSD name of the specified device
 func (c *Connection) GetDeviceOSDName(address int) string {
+	if err := c.lockLib(); err != nil {
+		return ""
+	}
+	defer c.libMu.Unlock()
+
 	name := make([]byte, 14)
 	C.libcec_get_device_osd_name(c.connection, C.cec_logical_address(address), (*C.char)(unsafe.Pointer(&name[0])))
 
-	return string(name)
+	return strings.TrimRight(string(name), "\x00")
+}
+
+// SetActiveSource - announce this adapter as the active source, which
+// makes the TV switch to our input
+func (c *Connection) SetActiveSource() error {
+	if err := c.lockLib(); err != nil {
+		return err
+	}
+	defer c.libMu.Unlock()
+	if !c.sent(C.libcec_set_active_source(c.connection, C.CEC_DEVICE_TYPE_RESERVED)) {
+		return fmt.Errorf("Error in cec_set_active_source: %w", ErrNotACKed)
+	}
+	return nil
+}
+
+// SetStreamPath - ask the TV to switch to the device with the given
+// physical address (e.g. 0x3000 for the device on HDMI 3)
+func (c *Connection) SetStreamPath(physicalAddr uint16) error {
+	if err := validPhysicalAddress(physicalAddr); err != nil {
+		return err
+	}
+	if err := c.lockLib(); err != nil {
+		return err
+	}
+	defer c.libMu.Unlock()
+	if !c.sent(C.libcec_set_stream_path_physical(c.connection, C.uint16_t(physicalAddr))) {
+		return fmt.Errorf("Error in cec_set_stream_path_physical: %w", ErrNotACKed)
+	}
+	return nil
+}
+
+// ActiveSource - ask the bus for the logical address of the current active
+// source (see RequestActiveSource). If no device answers, libcec's last known
+// active source is returned, which covers this adapter being the active
+// source, as it doesn't receive its own answer
+func (c *Connection) ActiveSource() (int, error) {
+	_, addr, err := c.RequestActiveSource(context.Background())
+	if err == nil {
+		return addr, nil
+	}
+	if err != ErrTimeout {
+		return 0, err
+	}
+
+	if err := c.lockLib(); err != nil {
+		return 0, err
+	}
+	defer c.libMu.Unlock()
+
+	result := C.libcec_get_active_source(c.connection)
+	if result == C.CECDEVICE_UNKNOWN {
+		return 0, errors.New("No active source")
+	}
+	return int(result), nil
+}
+
+// SetOSDName - change the OSD name of this adapter and announce it to the
+// TV. Names are limited to 14 bytes, longer names are truncated without
+// splitting a character and ErrOSDNameTruncated is returned after the
+// truncated name has been set
+func (c *Connection) SetOSDName(name string) error {
+	if c.isClosed() {
+		return ErrClosed
+	}
+
+	var truncated bool
+	if len(name) > 14 {
+		name = truncate(name, 14)
+		truncated = true
+	}
+
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+
+	err := c.updateConfiguration(func(conf *C.libcec_configuration) {
+		C.setName(conf, cname)
+	})
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.options.DeviceName = name
+	c.mu.Unlock()
+
+	err = c.transmitFromSelf(int(C.CECDEVICE_TV), 0x47, []byte(name))
+	if err != nil {
+		return err
+	}
+
+	if truncated {
+		return ErrOSDNameTruncated
+	}
+	return nil
+}
+
+// MyLogicalAddresses - get the logical addresses claimed by this adapter
+func (c *Connection) MyLogicalAddresses() ([]int, error) {
+	if err := c.lockLib(); err != nil {
+		return nil, err
+	}
+	defer c.libMu.Unlock()
+
+	if c.test {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return []int{c.testAddress}, nil
+	}
+
+	result := C.libcec_get_logical_addresses(c.connection)
+
+	var addresses []int
+	for i := 0; i < 16; i++ {
+		if int(result.addresses[i]) == 1 {
+			addresses = append(addresses, i)
+		}
+	}
+	if len(addresses) == 0 {
+		return nil, errors.New("No logical address claimed")
+	}
+	return addresses, nil
+}
+
+// ClaimedAddresses - get the logical addresses that libcec registered for
+// the configured device types. When presenting as several device types, the
+// bus may not have a free address for each of them, use DeviceTypeForAddress
+// to check which of them were granted
+func (c *Connection) ClaimedAddresses() ([]int, error) {
+	return c.MyLogicalAddresses()
+}
+
+// MyPhysicalAddress - get the physical address of this adapter
+func (c *Connection) MyPhysicalAddress() (uint16, error) {
+	if err := c.lockLib(); err != nil {
+		return 0, err
+	}
+	defer c.libMu.Unlock()
+
+	if c.test {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.testPhysicalAddress, nil
+	}
+
+	conf := C.allocConfiguration()
+	defer C.freeConfiguration(conf)
+
+	if C.libcec_get_current_configuration(c.connection, conf) != 1 {
+		return 0, errors.New("Error in cec_get_current_configuration")
+	}
+	return uint16(conf.iPhysicalAddress), nil
+}
+
+// SetPhysicalAddress - override the physical address that libcec detected
+// for this adapter (e.g. behind an HDMI switch that doesn't pass the EDID on)
+// and announce it on the bus. Use SetAutodetectAddress to go back to the
+// detected address
+func (c *Connection) SetPhysicalAddress(addr uint16) error {
+	if err := validPhysicalAddress(addr); err != nil {
+		return err
+	}
+
+	if err := c.lockLib(); err != nil {
+		return err
+	}
+	if C.libcec_set_physical_address(c.connection, C.uint16_t(addr)) != 1 {
+		c.libMu.Unlock()
+		return fmt.Errorf("Error in cec_set_physical_address: %w", ErrNotACKed)
+	}
+	c.libMu.Unlock()
+
+	self, err := c.logicalAddress()
+	if err != nil {
+		return err
+	}
+	return c.Transmit(self, AddrBroadcast, 0x84, []byte{byte(addr >> 8), byte(addr), byte(DeviceTypeForAddress(self))})
+}
+
+// SetAutodetectAddress - switch the detection of this adapter's physical
+// address on or off
+func (c *Connection) SetAutodetectAddress(enabled bool) error {
+	return c.updateConfiguration(func(conf *C.libcec_configuration) {
+		conf.bAutodetectAddress = cBool(enabled)
+	})
+}
+
+// SetMonitorMode - switch the adapter into (or out of) monitoring mode. In
+// monitoring mode it doesn't claim a logical address or acknowledge any
+// frame, it only reports the traffic on the bus, so any method that
+// transmits will fail
+func (c *Connection) SetMonitorMode(enabled bool) error {
+	if err := c.lockLib(); err != nil {
+		return err
+	}
+	defer c.libMu.Unlock()
+
+	var enable C.int
+	if enabled {
+		enable = 1
+	}
+	if C.libcec_switch_monitoring(c.connection, enable) != 1 {
+		return fmt.Errorf("Error in cec_switch_monitoring: %w", ErrNotACKed)
+	}
+	return nil
+}
+
+// SetDeviceTypes - change the device types this adapter presents itself as
+// (at most 5). libcec re-registers the adapter on the bus with the new types,
+// which may change its logical addresses
+func (c *Connection) SetDeviceTypes(types ...DeviceType) error {
+	if c.isClosed() {
+		return ErrClosed
+	}
+	if len(types) == 0 || len(types) > 5 {
+		return fmt.Errorf("Invalid number of device types (%d), 1 to 5 are allowed", len(types))
+	}
+
+	err := c.updateConfiguration(func(conf *C.libcec_configuration) {
+		setDeviceTypes(conf, types)
+	})
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.options.DeviceTypes = types
+	c.mu.Unlock()
+	return nil
+}
+
+// SetPowerOffOnStandby - put this adapter (and the system it is connected
+// to) in standby when the TV goes to standby
+func (c *Connection) SetPowerOffOnStandby(enabled bool) error {
+	return c.updateConfiguration(func(conf *C.libcec_configuration) {
+		conf.bPowerOffOnStandby = cBool(enabled)
+	})
+}
+
+// SetWakeOnActiveSource - make this adapter the active source when libcec
+// starts, which wakes up the TV and switches it to our input
+func (c *Connection) SetWakeOnActiveSource(enabled bool) error {
+	return c.updateConfiguration(func(conf *C.libcec_configuration) {
+		conf.bActivateSource = cBool(enabled)
+	})
+}
+
+func cBool(b bool) C.uint8_t {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// updateConfiguration - apply the changes made by fn to the current
+// libcec configuration
+func (c *Connection) updateConfiguration(fn func(conf *C.libcec_configuration)) error {
+	if err := c.lockLib(); err != nil {
+		return err
+	}
+	defer c.libMu.Unlock()
+
+	conf := C.allocConfiguration()
+	defer C.freeConfiguration(conf)
+
+	if C.libcec_get_current_configuration(c.connection, conf) != 1 {
+		return errors.New("Error in cec_get_current_configuration")
+	}
+
+	fn(conf)
+
+	if C.libcec_set_configuration(c.connection, conf) != 1 {
+		return errors.New("Error in cec_set_configuration")
+	}
+	return nil
+}
+
+// SetInitiator - use the given logical address instead of the primary one
+// as the source of the requests and commands this package builds itself
+// (e.g. the Audio address for system audio mode requests), a negative
+// address switches back to the primary one. It doesn't apply to the
+// commands libcec builds, which are always sent from the primary address:
+// keys (Key, KeyPress, VolumeUp, ...), Standby, StandbyAll, SetActiveSource,
+// SetStreamPath and the OSD strings. libcec only transmits from addresses it
+// has claimed, so the address must belong to one of the configured device
+// types (see SetDeviceTypes)
+func (c *Connection) SetInitiator(address int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.initiator = address
+	c.initiatorSet = address >= 0
+}
+
+// logicalAddress - the logical address commands are sent from, the primary
+// logical address of this adapter unless overridden by SetInitiator
+func (c *Connection) logicalAddress() (int, error) {
+	c.mu.Lock()
+	initiator, set := c.initiator, c.initiatorSet
+	if c.test && !set {
+		initiator, set = c.testAddress, true
+	}
+	c.mu.Unlock()
+
+	if set {
+		return initiator, nil
+	}
+
+	if err := c.lockLib(); err != nil {
+		return 0, err
+	}
+	defer c.libMu.Unlock()
+
+	primary := C.libcec_get_logical_addresses(c.connection).primary
+	if primary == C.CECDEVICE_UNKNOWN {
+		return 0, errors.New("No logical address claimed")
+	}
+	return int(primary), nil
+}
+
+// transmitFromSelf - send a command from the address returned by
+// logicalAddress
+func (c *Connection) transmitFromSelf(destination, opcode int, params []byte) error {
+	self, err := c.logicalAddress()
+	if err != nil {
+		return err
+	}
+	return c.Transmit(self, destination, opcode, params)
+}
+
+// GetCECVersion - get the CEC version (e.g. "1.4") of the device at the
+// given address
+func (c *Connection) GetCECVersion(address int) (string, error) {
+	version, err := c.DeviceCECVersion(address)
+	if err != nil {
+		return "", err
+	}
+	return version.String(), nil
+}
+
+// DeviceCECVersion - get the CEC version of the device at the given address
+func (c *Connection) DeviceCECVersion(address int) (CECVersion, error) {
+	if err := c.lockLib(); err != nil {
+		return 0, err
+	}
+	defer c.libMu.Unlock()
+
+	result := C.libcec_get_device_cec_version(c.connection, C.cec_logical_address(address))
+	if _, ok := cecVersions[int(result)]; !ok {
+		return 0, errors.New("Error in cec_get_device_cec_version")
+	}
+	return CECVersion(result), nil
 }
 
 // IsActiveSource - check if the device at the given address is the active source
 func (c *Connection) IsActiveSource(address int) bool {
+	if err := c.lockLib(); err != nil {
+		return false
+	}
+	defer c.libMu.Unlock()
+
 	result := C.libcec_is_active_source(c.connection, C.cec_logical_address(address))
 
 	if int(result) != 0 {
@@ -246,52 +1380,120 @@ func (c *Connection) IsActiveSource(address int) bool {
 
 // GetDeviceVendorID - Get the Vendor-ID of the device at the given address
 func (c *Connection) GetDeviceVendorID(address int) uint64 {
+	if err := c.lockLib(); err != nil {
+		return 0
+	}
+	defer c.libMu.Unlock()
+
 	result := C.libcec_get_device_vendor_id(c.connection, C.cec_logical_address(address))
 
 	return uint64(result)
 }
 
+// DeviceVendor - get the vendor ID and name of the device at the given
+// address, unknown vendors are named by their ID in hex
+func (c *Connection) DeviceVendor(address int) (id uint64, name string, err error) {
+	if c.isClosed() {
+		return 0, "", ErrClosed
+	}
+
+	id = c.GetDeviceVendorID(address)
+	if id == 0 {
+		return 0, "", fmt.Errorf("%w: no vendor ID reported by device %d", ErrDeviceNotPresent, address)
+	}
+	return id, GetVendorByID(id), nil
+}
+
 // GetDevicePhysicalAddress - Get the physical address of the device at
 // the given logical address
 func (c *Connection) GetDevicePhysicalAddress(address int) string {
+	if err := c.lockLib(); err != nil {
+		return ParsePhysicalAddress(0xFFFF)
+	}
+	defer c.libMu.Unlock()
+
 	result := C.libcec_get_device_physical_address(c.connection, C.cec_logical_address(address))
 
-	return fmt.Sprintf("%x.%x.%x.%x", (uint(result)>>12)&0xf, (uint(result)>>8)&0xf, (uint(result)>>4)&0xf, uint(result)&0xf)
+	return ParsePhysicalAddress(uint16(result))
 }
 
-// Poll device - poll the device at
-// the given logical address
-func (c *Connection) PollDevice(address int) string {
+// PollDevice - send a POLL message to the device at the given logical
+// address, returns true when the device acknowledged it
+func (c *Connection) PollDevice(address int) (bool, error) {
+	if err := c.lockLib(); err != nil {
+		return false, err
+	}
+	defer c.libMu.Unlock()
+
 	result := C.libcec_poll_device(c.connection, C.cec_logical_address(address))
 
-	return fmt.Sprintf("%T: %+v", result, result)
+	return result == 1, nil
 }
 
-//extern DECLSPEC int libcec_set_osd_string(libcec_connection_t connection, cec_namespace cec_logical_address ilogicaladdress, cec_namespace cec_display_control duration, const char* strmessage);
+// SetOSDString - display a message on the screen of the device at the
+// given address for the default time
 func (c *Connection) SetOSDString(address int, str string) error {
-	msg := []byte(str)
-	if C.libcec_set_osd_string(c.connection, C.cec_logical_address(address), C.cec_display_control(1), (*C.char)(unsafe.Pointer(&msg[0]))) != 0 {
-		return errors.New("Error in cec_set_osd_string")
+	return c.setOSDString(address, str, OSDDefaultTime)
+}
+
+// ShowOSDString - display a message on the TV screen. The CEC spec limits
+// OSD strings to 13 characters, longer text is cut off after 13 characters
+func (c *Connection) ShowOSDString(text string, duration OSDDuration) error {
+	return c.setOSDString(int(C.CECDEVICE_TV), text, duration)
+}
+
+// ClearOSDString - remove the message shown by ShowOSDString from the TV
+// screen, including one shown with OSDUntilCleared
+func (c *Connection) ClearOSDString() error {
+	// the OSD string operand can't be empty, the blank is not displayed
+	return c.setOSDString(int(C.CECDEVICE_TV), " ", OSDClearPrevious)
+}
+
+func (c *Connection) setOSDString(address int, text string, duration OSDDuration) error {
+	if err := c.lockLib(); err != nil {
+		return err
+	}
+	defer c.libMu.Unlock()
+	text = truncate(text, 13)
+
+	msg := C.CString(text)
+	defer C.free(unsafe.Pointer(msg))
+
+	if !c.sent(C.libcec_set_osd_string(c.connection, C.cec_logical_address(address), C.cec_display_control(duration), msg)) {
+		return fmt.Errorf("Error in cec_set_osd_string: %w", ErrNotACKed)
 	}
 	return nil
 }
 
 // GetDevicePowerStatus - Get the power status of the device at the
-// given address
+// given address (e.g. "standby"), or "" if it isn't known
 func (c *Connection) GetDevicePowerStatus(address int) string {
+	status := c.DevicePowerStatus(address)
+	if status == PowerUnknown {
+		return ""
+	}
+	return status.String()
+}
+
+// DevicePowerStatus - get the power status of the device at the given address
+func (c *Connection) DevicePowerStatus(address int) PowerStatus {
+	if err := c.lockLib(); err != nil {
+		return PowerUnknown
+	}
+	defer c.libMu.Unlock()
+
 	result := C.libcec_get_device_power_status(c.connection, C.cec_logical_address(address))
 
-	// C.CEC_POWER_STATUS_UNKNOWN == error
-
-	if int(result) == C.CEC_POWER_STATUS_ON {
-		return "on"
-	} else if int(result) == C.CEC_POWER_STATUS_STANDBY {
-		return "standby"
-	} else if int(result) == C.CEC_POWER_STATUS_IN_TRANSITION_STANDBY_TO_ON {
-		return "starting"
-	} else if int(result) == C.CEC_POWER_STATUS_IN_TRANSITION_ON_TO_STANDBY {
-		return "shutting down"
-	} else {
-		return ""
+	switch result {
+	case C.CEC_POWER_STATUS_ON:
+		return PowerOn
+	case C.CEC_POWER_STATUS_STANDBY:
+		return PowerStandby
+	case C.CEC_POWER_STATUS_IN_TRANSITION_STANDBY_TO_ON:
+		return PowerTransitionToOn
+	case C.CEC_POWER_STATUS_IN_TRANSITION_ON_TO_STANDBY:
+		return PowerTransitionToStandby
+	default:
+		return PowerUnknown
 	}
 }